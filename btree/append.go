@@ -0,0 +1,571 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"maps"
+	"slices"
+)
+
+var (
+	ErrReadOnly = errors.New("btree: a snapshot can't be committed")
+	ErrNotAppendFile = errors.New("btree: file has no valid superblock")
+)
+
+// An append only file starts with two superblocks, the valid one with the
+// higher sequence number holds the last commit:
+//
+//	magic [4]byte, version uint32, sequence, table, end uint64, checksum uint32
+//
+// After them come node images in the layout of a node page, and a table for
+// each commit:
+//
+//	previous table, sequence uint64, root, nodes, full, checksum uint32
+//	nodes * (node, length uint32, offset uint64)
+//
+// A full table lists every node, the others only the nodes changed since the
+// previous table, with a length of 0 for a released node. The checksum of a
+// table is the CRC32C of the rest of it. Every node above a changed node is
+// changed too, so an image is only shared by commits that share its subtree.
+const (
+	appendMagic = "BTAO"
+	appendVersion = 5
+	appendSuper = 64
+	appendTable = 32
+	appendEntry = 16
+)
+
+// Where the image of a node is in the file
+type extent struct {
+	offset int64
+	length int
+}
+
+// A committed version of the tree
+type Snapshot struct {
+	Seq uint64
+	table int64
+}
+
+// A NodeFactory that never writes over a committed node. Nodes are numbered
+// and a table maps the numbers to the last image of each node, so that a leaf
+// can be rewritten without rewriting its neighbors. Stored nodes are kept in
+// memory until Commit appends them, the nodes on the paths to them and a
+// table of the changed nodes to the file, then switches the root by writing a
+// superblock. The whole table is written again once the changes since it
+// outnumber the nodes, so a commit costs about as much as its changes and
+// opening reads at most two tables' worth of entries. A crash before the
+// superblock is written leaves the last commit, and every commit stays
+// readable as a Snapshot until the file is compacted.
+type AppendFactory[K any, V any] struct {
+	file PageFile
+	base *AppendFactory[K, V]
+	keys Codec[K]
+	values Codec[V]
+	compare func(a, b K) int
+	table map[uint32] extent
+	dirty map[uint32] [] byte
+	levels map[uint32] byte
+	free [] uint32
+	next uint32
+	root uint32
+	seq uint64
+	last int64
+	end int64
+	changes int
+	readonly bool
+	err error
+}
+
+// A node stored by an AppendFactory
+type AppendNode[K any, V any] struct {
+	factory *AppendFactory[K, V]
+	id uint32
+}
+
+// Start an append only file in file with an empty first commit
+func NewAppendFactory[K any, V any](file PageFile, compare func(a, b K) int, keys Codec[K], values Codec[V]) (*AppendFactory[K, V], error) {
+	if keys.Size() <= 0 || values.Size() <= 0 {
+		return nil, ErrCodec
+	}
+	self := &AppendFactory[K, V]{file: file, keys: keys, values: values, compare: compare, end: 2 * appendSuper}
+	self.base = self
+	self.use(map[uint32] extent {})
+	if err := self.Commit(nil); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+// Open the last commit of a file started by NewAppendFactory
+func OpenAppendFactory[K any, V any](file PageFile, compare func(a, b K) int, keys Codec[K], values Codec[V]) (*AppendFactory[K, V], error) {
+	if keys.Size() <= 0 || values.Size() <= 0 {
+		return nil, ErrCodec
+	}
+	self := &AppendFactory[K, V]{file: file, keys: keys, values: values, compare: compare}
+	self.base = self
+	found := false
+	for slot := int64(0); slot < 2; slot++ {
+		buf := make ([] byte, appendSuper)
+		if _, err := file.ReadAt(buf, slot * appendSuper); err != nil {
+			continue
+		}
+		if string(buf[0:4]) != appendMagic || binary.LittleEndian.Uint32(buf[4:]) != appendVersion {
+			continue
+		}
+		if crc32.Checksum(buf[:32], castagnoli) != binary.LittleEndian.Uint32(buf[32:]) {
+			continue
+		}
+		if seq := binary.LittleEndian.Uint64(buf[8:]); ! found || seq > self.seq {
+			found = true
+			self.seq = seq
+			self.last = int64(binary.LittleEndian.Uint64(buf[16:]))
+			self.end = int64(binary.LittleEndian.Uint64(buf[24:]))
+		}
+	}
+	if ! found {
+		return nil, ErrNotAppendFile
+	}
+	return self, self.load(self.last)
+}
+
+// The commits in the file, the last one first
+func (self *AppendFactory[K, V]) Snapshots() ([] Snapshot, error) {
+	var snapshots [] Snapshot
+	buf := make ([] byte, appendTable)
+	for at := self.last; at != 0; at = int64(binary.LittleEndian.Uint64(buf[0:])) {
+		if _, err := self.file.ReadAt(buf, at); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, Snapshot{binary.LittleEndian.Uint64(buf[8:]), at})
+	}
+	return snapshots, nil
+}
+
+// A factory for the tree as it was at s. It can be changed in memory but
+// not committed. Diff skips the subtrees it shares with the other commits.
+func (self *AppendFactory[K, V]) At(s Snapshot) (*AppendFactory[K, V], error) {
+	view := &AppendFactory[K, V]{file: self.file, base: self.base, keys: self.keys, values: self.values, compare: self.compare, readonly: true}
+	return view, view.load(s.table)
+}
+
+// Copy the last commit to an empty file, leaving out the older commits and
+// the nodes only they use. Changes since the last commit are not copied. The
+// tree has to be opened again with the new factory.
+func (self *AppendFactory[K, V]) Compact(to PageFile) (*AppendFactory[K, V], error) {
+	c := &AppendFactory[K, V]{file: to, keys: self.keys, values: self.values, compare: self.compare, end: 2 * appendSuper}
+	c.base = c
+	table := map[uint32] extent {}
+	for id := range self.table {
+		table[id] = extent{}
+	}
+	c.use(table)
+	for id, e := range self.table {
+		image := make ([] byte, e.length)
+		if _, err := self.file.ReadAt(image, e.offset); err != nil {
+			return nil, err
+		}
+		c.dirty[id] = image
+	}
+	if err := c.Commit(c.node(self.root)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Append the stored nodes and a table of them to the file, then make root the
+// root of the last commit
+func (self *AppendFactory[K, V]) Commit(root Node[K, V]) error {
+	if self.readonly {
+		return ErrReadOnly
+	}
+	if self.err != nil {
+		return self.err
+	}
+	self.paths(self.id(root))
+	var changed [] uint32
+	for _, id := range slices.Sorted(maps.Keys(self.dirty)) {
+		image := self.dirty[id]
+		if image == nil {
+			if _, ok := self.table[id]; ok {
+				delete(self.table, id)
+				changed = append(changed, id)
+			}
+			continue
+		}
+		self.write(image, self.end)
+		self.table[id] = extent{self.end, len(image)}
+		self.end += int64(len(image))
+		changed = append(changed, id)
+	}
+	clear(self.dirty)
+
+	// The first table lists every node, and so does the table that brings the
+	// changes since the last full one up to the number of nodes
+	full := self.last == 0 || self.changes + len(changed) >= len(self.table)
+	if full {
+		changed = slices.Sorted(maps.Keys(self.table))
+		self.changes = 0
+	}else{
+		self.changes += len(changed)
+	}
+	buf := make ([] byte, appendTable + appendEntry * len(changed))
+	binary.LittleEndian.PutUint64(buf[0:], uint64(self.last))
+	binary.LittleEndian.PutUint64(buf[8:], self.seq + 1)
+	binary.LittleEndian.PutUint32(buf[16:], self.id(root))
+	binary.LittleEndian.PutUint32(buf[20:], uint32(len(changed)))
+	if full {
+		binary.LittleEndian.PutUint32(buf[24:], 1)
+	}
+	at := appendTable
+	for _, id := range changed {
+		binary.LittleEndian.PutUint32(buf[at:], id)
+		binary.LittleEndian.PutUint32(buf[at+4:], uint32(self.table[id].length))
+		binary.LittleEndian.PutUint64(buf[at+8:], uint64(self.table[id].offset))
+		at += appendEntry
+	}
+	binary.LittleEndian.PutUint32(buf[28:], tableChecksum(buf))
+	self.write(buf, self.end)
+	self.last = self.end
+	self.end += int64(len(buf))
+	self.fail(syncFile(self.file))
+	if self.err != nil {
+		return self.err
+	}
+
+	// The superblock switches to the new commit
+	self.seq++
+	self.root = self.id(root)
+	super := make ([] byte, appendSuper)
+	copy(super, appendMagic)
+	binary.LittleEndian.PutUint32(super[4:], appendVersion)
+	binary.LittleEndian.PutUint64(super[8:], self.seq)
+	binary.LittleEndian.PutUint64(super[16:], uint64(self.last))
+	binary.LittleEndian.PutUint64(super[24:], uint64(self.end))
+	binary.LittleEndian.PutUint32(super[32:], crc32.Checksum(super[:32], castagnoli))
+	self.write(super, int64(self.seq % 2) * appendSuper)
+	self.fail(syncFile(self.file))
+	return self.err
+}
+
+// The root of the last commit, nil when it has none
+func (self *AppendFactory[K, V]) Root() Node[K, V] {
+	return self.node(self.root)
+}
+
+// The first error from reading or writing the file
+func (self *AppendFactory[K, V]) Err() error {
+	return self.err
+}
+
+func (self *AppendFactory[K, V]) NewNode(order int) Node[K, V] {
+	return self.allocate(&MemNode[K, V]{Nodes: [] Node[K, V] {}})
+}
+
+func (self *AppendFactory[K, V]) NewLeaf(order int) Node[K, V] {
+	return self.allocate(&MemNode[K, V]{})
+}
+
+// Drop the node from the next commit, its number is used again
+func (self *AppendFactory[K, V]) Release(n Node[K, V]) {
+	if self.err != nil {
+		return
+	}
+	id := self.id(n)
+	self.dirty[id] = nil
+	delete(self.levels, id)
+	self.free = append(self.free, id)
+}
+
+func (self *AppendFactory[K, V]) allocate(mem *MemNode[K, V]) Node[K, V] {
+	id := self.next
+	if len(self.free) > 0 {
+		id = self.free[len(self.free)-1]
+		self.free = self.free[:len(self.free)-1]
+	}else{
+		self.next++
+	}
+	delete(self.levels, id)
+	n := AppendNode[K, V]{self, id}
+	n.Store(mem)
+	return n
+}
+
+// Store again the nodes on the paths from root to the stored nodes, so that a
+// node keeps its image while nothing under it changes. The first key of a
+// stored node leads to it, and each node is descended into once for all of
+// the keys below it.
+func (self *AppendFactory[K, V]) paths(root uint32) {
+	var targets [] Entry[K, uint32]
+	for id, image := range self.dirty {
+		if image == nil || id == root {
+			continue
+		}
+		if m := (AppendNode[K, V]{self, id}).mem(); len(m.Entries) > 0 {
+			targets = append(targets, Entry[K, uint32]{m.Entries[0].Key, id})
+		}
+	}
+	slices.SortFunc(targets, func(a, b Entry[K, uint32]) int {
+		return self.compare(a.Key, b.Key)
+	})
+	var descend func(id uint32, targets [] Entry[K, uint32])
+	descend = func(id uint32, targets [] Entry[K, uint32]) {
+		if _, ok := self.dirty[id]; ! ok {
+			self.dirty[id] = self.image(id)
+		}
+		targets = slices.DeleteFunc(targets, func(e Entry[K, uint32]) bool {
+			return e.Value == id
+		})
+		m := AppendNode[K, V]{self, id}.mem()
+		if m.Nodes == nil {
+			return
+		}
+		for i := 0; i < len(targets); {
+			pos, match := slices.BinarySearchFunc(m.Entries, targets[i].Key, func(e Entry[K, V], key K) int {
+				return self.compare(e.Key, key)
+			})
+			if match {
+				pos++
+			}
+			j := i + 1
+			for j < len(targets) && (pos == len(m.Entries) || self.compare(targets[j].Key, m.Entries[pos].Key) < 0) {
+				j++
+			}
+			descend(self.id(m.Nodes[pos]), targets[i:j])
+			i = j
+		}
+	}
+	if root != 0 && len(targets) > 0 {
+		descend(root, targets)
+	}
+}
+
+// Read the table at offset as the last commit, starting from the full table
+// before it and applying the changes listed since
+func (self *AppendFactory[K, V]) load(offset int64) error {
+	var tables [] [] byte
+	for at := offset; ; {
+		if at == 0 {
+			return ErrCorruptPage
+		}
+		buf, err := self.readTable(at)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, buf)
+		if binary.LittleEndian.Uint32(buf[24:]) != 0 {
+			break
+		}
+		at = int64(binary.LittleEndian.Uint64(buf[0:]))
+	}
+	table := map[uint32] extent {}
+	changes := 0
+	for i := len(tables) - 1; i >= 0; i-- {
+		buf := tables[i]
+		for at := appendTable; at < len(buf); at += appendEntry {
+			id := binary.LittleEndian.Uint32(buf[at:])
+			e := extent{int64(binary.LittleEndian.Uint64(buf[at+8:])), int(binary.LittleEndian.Uint32(buf[at+4:]))}
+			if e.length == 0 {
+				delete(table, id)
+			}else{
+				table[id] = e
+			}
+		}
+		if i < len(tables) - 1 {
+			changes += (len(buf) - appendTable) / appendEntry
+		}
+	}
+	self.root = binary.LittleEndian.Uint32(tables[0][16:])
+	self.use(table)
+	self.changes = changes
+	return nil
+}
+
+// Read and check the table at offset
+func (self *AppendFactory[K, V]) readTable(offset int64) ([] byte, error) {
+	head := make ([] byte, appendTable)
+	if _, err := self.file.ReadAt(head, offset); err != nil {
+		return nil, err
+	}
+	buf := make ([] byte, appendTable + appendEntry * int(binary.LittleEndian.Uint32(head[20:])))
+	if _, err := self.file.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	if tableChecksum(buf) != binary.LittleEndian.Uint32(buf[28:]) {
+		return nil, ErrCorruptPage
+	}
+	return buf, nil
+}
+
+// Start from table, the numbers it doesn't use are free
+func (self *AppendFactory[K, V]) use(table map[uint32] extent) {
+	self.table = table
+	self.dirty = map[uint32] [] byte {}
+	self.levels = map[uint32] byte {}
+	self.free = nil
+	self.next = 1
+	for id := range table {
+		self.next = max(self.next, id + 1)
+	}
+	for id := self.next - 1; id > 0; id-- {
+		if _, ok := table[id]; ! ok {
+			self.free = append(self.free, id)
+		}
+	}
+}
+
+// The last image of a node, a zeroed leaf when it can't be read
+func (self *AppendFactory[K, V]) image(id uint32) [] byte {
+	if image, ok := self.dirty[id]; ok && image != nil {
+		return image
+	}
+	e := self.table[id]
+	image := make ([] byte, max(e.length, pageHeader))
+	if _, err := self.file.ReadAt(image[:e.length], e.offset); err != nil {
+		self.fail(err)
+		clear(image)
+	}else if checksum(image) != binary.LittleEndian.Uint32(image[12:]) {
+		self.fail(&PageError{id, ErrCorruptPage})
+		clear(image)
+	}
+	return image
+}
+
+// The CRC32C of a table, leaving out its checksum
+func tableChecksum(buf [] byte) uint32 {
+	crc := crc32.Checksum(buf[:28], castagnoli)
+	return crc32.Update(crc, castagnoli, buf[appendTable:])
+}
+
+func (self *AppendFactory[K, V]) write(buf [] byte, offset int64) {
+	if self.err != nil {
+		return
+	}
+	if _, err := self.file.WriteAt(buf, offset); err != nil {
+		self.fail(err)
+	}
+}
+
+func (self *AppendFactory[K, V]) fail(err error) {
+	if self.err == nil && err != nil {
+		self.err = err
+	}
+}
+
+// The node for a number, nil for 0
+func (self *AppendFactory[K, V]) node(id uint32) Node[K, V] {
+	if id == 0 {
+		return nil
+	}
+	return AppendNode[K, V]{self, id}
+}
+
+// The number of a node, 0 for nil
+func (self *AppendFactory[K, V]) id(n Node[K, V]) uint32 {
+	if n == nil {
+		return 0
+	}
+	return n.(AppendNode[K, V]).id
+}
+
+// A node that can't be read is an empty leaf
+func (self AppendNode[K, V]) isLeaf() bool {
+	f := self.factory
+	image := f.image(self.id)
+	return image[0] != pageNode || ! checkNode(image, self.id, f.levels, f.keys, f.values)
+}
+
+func (self AppendNode[K, V]) Find(key K) (int, bool) {
+	mem := self.mem()
+	pos, match := slices.BinarySearchFunc(mem.Entries, key, func(e Entry[K, V], key K) int {
+		return self.factory.compare(e.Key, key)
+	})
+	if match {
+		pos++
+	}
+	return pos, match
+}
+
+func (self AppendNode[K, V]) Size() int {
+	return int(binary.LittleEndian.Uint16(self.factory.image(self.id)[2:]))
+}
+
+func (self AppendNode[K, V]) Child(pos int) Node[K, V] {
+	return self.mem().Nodes[pos]
+}
+
+func (self AppendNode[K, V]) Count(pos int) int {
+	return self.mem().Counts[pos]
+}
+
+func (self AppendNode[K, V]) Value(pos int) V {
+	return self.mem().Entries[pos-1].Value
+}
+
+func (self AppendNode[K, V]) Load(mem *MemNode[K, V]) {
+	f := self.factory
+	image := f.image(self.id)
+	if ! checkNode(image, self.id, f.levels, f.keys, f.values) {
+		f.fail(&PageError{self.id, ErrCorruptPage})
+		return
+	}
+	decodeNode(image, mem, f.keys, f.values, f.node)
+	expectLevels(f.levels, mem, image[1], f.id)
+}
+
+// Keep the image in memory until the next commit, nothing is kept after an
+// error because it can't be committed
+func (self AppendNode[K, V]) Store(mem *MemNode[K, V]) {
+	f := self.factory
+	level := byte(0)
+	if mem.Nodes != nil {
+		level = 1
+	}
+	if len(mem.Nodes) > 0 {
+		level = f.image(f.id(mem.Nodes[0]))[1] + 1
+	}
+	if f.err != nil {
+		return
+	}
+	image := make ([] byte, nodeSize(mem, f.keys, f.values))
+	encodeNode(image, mem, self.id, level, f.keys, f.values, f.id)
+	binary.LittleEndian.PutUint32(image[12:], checksum(image))
+	f.dirty[self.id] = image
+	f.levels[self.id] = level
+}
+
+func (self AppendNode[K, V]) Dump(c chan Entry[K, V]) {
+	for _, e := range self.mem().Entries {
+		c <- e
+	}
+}
+
+func (self AppendNode[K, V]) Next() Node[K, V] {
+	return self.mem().neighbor
+}
+
+func (self AppendNode[K, V]) Prev() Node[K, V] {
+	return self.mem().previous
+}
+
+// Nodes of two commits of one file hold the same subtree when they have the
+// same image, since a commit stores every node above a changed node again.
+// Changes that are not committed yet rule it out.
+func (self AppendNode[K, V]) shares(n Node[K, V]) bool {
+	other, ok := n.(AppendNode[K, V])
+	if ! ok || self.factory.base != other.factory.base || self.id != other.id {
+		return false
+	}
+	if len(self.factory.dirty) > 0 || len(other.factory.dirty) > 0 {
+		return false
+	}
+	e, ok := self.factory.table[self.id]
+	return ok && e == other.factory.table[other.id]
+}
+
+func (self AppendNode[K, V]) mem() *MemNode[K, V] {
+	mem := new (MemNode[K, V])
+	self.Load(mem)
+	return mem
+}
@@ -0,0 +1,245 @@
+package btree
+
+import (
+	"slices"
+)
+
+// A Batch collects puts and deletes that are applied to a BPlusTree together
+// by ApplyBatch. When a key is written more than once the last write wins.
+type Batch[K any, V any] struct {
+	ops [] batchOp[K, V]
+}
+
+type batchOp[K any, V any] struct {
+	Entry[K, V]
+	remove bool
+}
+
+// Queue an insert of a key/value
+func (self *Batch[K, V]) Put(key K, value V) {
+	self.ops = append(self.ops, batchOp[K, V]{Entry[K, V]{key, value}, false})
+}
+
+// Queue the removal of a key
+func (self *Batch[K, V]) Delete(key K) {
+	var none V
+	self.ops = append(self.ops, batchOp[K, V]{Entry[K, V]{key, none}, true})
+}
+
+// The number of queued operations
+func (self *Batch[K, V]) Len() int {
+	return len(self.ops)
+}
+
+// Drop all queued operations
+func (self *Batch[K, V]) Reset() {
+	self.ops = self.ops[:0]
+}
+
+// Apply the operations in batch. The operations are sorted by key and every
+// node is descended into once for all of the keys that land under it, so the
+// keys that share a leaf cost one load and one store of that leaf.
+func (self *BPlusTree[K, V]) ApplyBatch(batch *Batch[K, V]) {
+	ops := slices.Clone(batch.ops)
+	slices.SortStableFunc(ops, func(a, b batchOp[K, V]) int {
+		return self.compare(a.Key, b.Key)
+	})
+	last := 0
+	for i := range ops {
+		if i > 0 && self.compare(ops[i].Key, ops[last].Key) == 0 {
+			ops[last] = ops[i]
+		}else if i > 0 {
+			last++
+			ops[last] = ops[i]
+		}
+	}
+	if len(ops) == 0 || self.Err() != nil {
+		return
+	}
+	self.plant()
+	ops = ops[:last+1]
+
+	var apply func(n Node[K, V], ops [] batchOp[K, V]) (*MemNode[K, V], [] *MemNode[K, V], [] Entry[K, V], int)
+	apply = func(n Node[K, V], ops [] batchOp[K, V]) (me *MemNode[K, V], pieces [] *MemNode[K, V], seps [] Entry[K, V], delta int) {
+		me = self.load(n)
+		if n.isLeaf() {
+			// Merge the operations into the entries
+			entries := make ([] Entry[K, V], 0, len(me.Entries) + len(ops))
+			i := 0
+			for _, op := range ops {
+				for i < len(me.Entries) && self.compare(me.Entries[i].Key, op.Key) < 0 {
+					entries = append(entries, me.Entries[i])
+					i++
+				}
+				exists := i < len(me.Entries) && self.compare(me.Entries[i].Key, op.Key) == 0
+				if exists {
+					i++
+				}
+				if op.remove {
+					if exists {
+						delta--
+					}
+				}else{
+					entries = append(entries, op.Entry)
+					if ! exists {
+						delta++
+					}
+				}
+			}
+			me.Entries = append(entries, me.Entries[i:]...)
+		}else{
+			for i := 0; i < len(ops); {
+				// The operations for one child end at its separator
+				pos, _ := self.find(me, ops[i].Key)
+				j := len(ops)
+				if pos < len(me.Entries) {
+					j = i + 1
+					for j < len(ops) && self.compare(ops[j].Key, me.Entries[pos].Key) < 0 {
+						j++
+					}
+				}
+				child, more, moreseps, d := apply(me.Nodes[pos], ops[i:j])
+				delta += d
+				me.Counts[pos] = self.total(child)
+				if len(more) > 0 {
+					self.store(child)
+					counts := make ([] int, len(more))
+					refs := make ([] Node[K, V], len(more))
+					for k, m := range more {
+						self.store(m)
+						counts[k] = self.total(m)
+						refs[k] = m.ref
+					}
+					me.Entries = slices.Insert(me.Entries, pos, moreseps...)
+					me.Nodes = slices.Insert(me.Nodes, pos+1, refs...)
+					me.Counts = slices.Insert(me.Counts, pos+1, counts...)
+				}else if len(child.Entries) < self.N/2 && len(me.Nodes) > 1 {
+					self.mend(pos, child, me)
+				}else{
+					self.store(child)
+				}
+				i = j
+			}
+		}
+		if len(me.Entries) > self.N {
+			pieces, seps = self.divide(me)
+		}
+		return
+	}
+	me, pieces, seps, delta := apply(self.root, ops)
+	self.size += delta
+
+	// Grow new roots until everything fits under one node
+	for len(pieces) > 0 {
+		self.store(me)
+		newroot := self.blank(self.factory.NewNode(self.N))
+		newroot.Nodes = append(newroot.Nodes, me.ref)
+		newroot.Counts = append(newroot.Counts, self.total(me))
+		for i, m := range pieces {
+			self.store(m)
+			newroot.Entries = append(newroot.Entries, seps[i])
+			newroot.Nodes = append(newroot.Nodes, m.ref)
+			newroot.Counts = append(newroot.Counts, self.total(m))
+		}
+		me, pieces, seps = newroot, nil, nil
+		if len(me.Entries) > self.N {
+			pieces, seps = self.divide(me)
+		}
+	}
+	// or shrink the tree while the root has a single child
+	for me.Nodes != nil && len(me.Nodes) == 1 {
+		self.factory.Release(me.ref)
+		me = self.load(me.Nodes[0])
+	}
+	self.store(me)
+	self.root = me.ref
+}
+
+// Balance the underfull child at pos of m with a sibling. A child that was
+// left with a single child of its own hands it over to the joined node, where
+// it may need balancing in turn.
+func (self *BPlusTree[K, V]) mend(pos int, child *MemNode[K, V], m *MemNode[K, V]) {
+	lone := child.Nodes != nil && len(child.Nodes) == 1
+	size := len(m.Nodes)
+	self.pivot(pos, child, m)
+	if ! lone {
+		return
+	}
+	// Find the orphan at the seam of the join
+	at := 0
+	if pos > 0 {
+		at = pos - 1
+		if len(m.Nodes) == size {
+			at = pos
+		}
+	}
+	holder := self.load(m.Nodes[at])
+	gi := 0
+	if pos > 0 {
+		gi = len(holder.Nodes) - 1
+	}
+	orphan := self.load(holder.Nodes[gi])
+	if len(orphan.Entries) >= self.N/2 {
+		return
+	}
+	self.mend(gi, orphan, holder)
+	if len(holder.Entries) < self.N/2 && len(m.Nodes) > 1 {
+		self.mend(at, holder, m)
+	}else{
+		self.store(holder)
+	}
+}
+
+// The position of the first key in m greater than key and whether the key
+// before it is a match, like Node.Find
+func (self *BPlusTree[K, V]) find(m *MemNode[K, V], key K) (int, bool) {
+	pos, match := slices.BinarySearchFunc(m.Entries, key, func(e Entry[K, V], key K) int {
+		return self.compare(e.Key, key)
+	})
+	if match {
+		pos++
+	}
+	return pos, match
+}
+
+// Break an overfull node into pieces that are between half and completely
+// full. The node keeps the first piece, the rest are returned unstored with 
+// the separators that go in front of them.
+func (self *BPlusTree[K, V]) divide(m *MemNode[K, V]) (pieces [] *MemNode[K, V], seps [] Entry[K, V]) {
+	if m.Nodes == nil {
+		sizes := chunks(len(m.Entries), self.N, self.N/2)
+		entries := m.Entries
+		m.Entries = entries[:sizes[0]:sizes[0]]
+		start := sizes[0]
+		prev := m
+		for _, size := range sizes[1:] {
+			p := self.blank(self.factory.NewLeaf(self.N))
+			p.Entries = append(p.Entries, entries[start:start+size]...)
+			p.neighbor = prev.neighbor
+			p.previous = prev.ref
+			prev.neighbor = p.ref
+			pieces = append(pieces, p)
+			seps = append(seps, Entry[K, V]{Key: entries[start].Key})
+			start += size
+			prev = p
+		}
+		self.relink(prev)
+	}else{
+		sizes := chunks(len(m.Nodes), self.N + 1, self.N/2 + 1)
+		entries, nodes, counts := m.Entries, m.Nodes, m.Counts
+		m.Entries = entries[:sizes[0]-1:sizes[0]-1]
+		m.Nodes = nodes[:sizes[0]:sizes[0]]
+		m.Counts = counts[:sizes[0]:sizes[0]]
+		start := sizes[0]
+		for _, size := range sizes[1:] {
+			p := self.blank(self.factory.NewNode(self.N))
+			p.Entries = append(p.Entries, entries[start:start+size-1]...)
+			p.Nodes = append(p.Nodes, nodes[start:start+size]...)
+			p.Counts = append(p.Counts, counts[start:start+size]...)
+			pieces = append(pieces, p)
+			seps = append(seps, entries[start-1])
+			start += size
+		}
+	}
+	return
+}
@@ -2,105 +2,318 @@ package btree
 
 import (
 //	"fmt"
-	"testing"
+	"cmp"
 )
 
-type BPlusTree struct {
+type BPlusTree[K any, V any] struct {
 	N int
-	root Node
-	factory SimpleFactory
-	head Node
+	root Node[K, V]
+	factory NodeFactory[K, V]
+	compare func(a, b K) int
+	head Node[K, V]
+	tail Node[K, V]
+	size int
 }
 
-type MemNode struct {
-	ref Node
-	neighbor Node
-	Entries [] Entry
-	Nodes [] Node
+type MemNode[K any, V any] struct {
+	ref Node[K, V]
+	neighbor Node[K, V]
+	previous Node[K, V]
+	Entries [] Entry[K, V]
+	Nodes [] Node[K, V]
+	Counts [] int
 }
 
-// Initialzie the tree
-func NewBPlusTree(order int) (self *BPlusTree) {
-	self = new (BPlusTree)
+// Initialize a tree ordered by the natural order of its keys
+func NewBPlusTree[K cmp.Ordered, V any](order int) *BPlusTree[K, V] {
+	return NewBPlusTreeFunc[K, V](order, cmp.Compare[K])
+}
+
+// Initialize a tree ordered by compare, which returns a negative number,
+// zero or a positive number when a < b, a == b or a > b (see cmp.Compare)
+func NewBPlusTreeFunc[K any, V any](order int, compare func(a, b K) int) *BPlusTree[K, V] {
+	return NewBPlusTreeFactory[K, V](order, compare, NewSimpleFactory[K, V](compare))
+}
+
+// Initialize a tree ordered by compare that keeps its nodes in factory
+func NewBPlusTreeFactory[K any, V any](order int, compare func(a, b K) int, factory NodeFactory[K, V]) (self *BPlusTree[K, V]) {
+	self = new (BPlusTree[K, V])
 	self.N = order
+	self.compare = compare
+	self.factory = factory
 	self.root = self.factory.NewLeaf(order)
 	self.head = self.root
+	self.tail = self.root
 	return
 }
 
-// Fetch by key
-func (self *BPlusTree) Get(key interface{}) interface{} {
-	var recurse func (Node) interface{}
-	recurse = func(n Node) (interface{}) {
-		_, _, next := n.Find(key)
+// Open the tree last committed to factory, or a new tree when nothing was
+// committed. The order must be the one the tree was committed with.
+func OpenBPlusTree[K any, V any](order int, compare func(a, b K) int, factory Committer[K, V]) *BPlusTree[K, V] {
+	root := factory.Root()
+	if root == nil {
+		return NewBPlusTreeFactory[K, V](order, compare, factory)
+	}
+	self := &BPlusTree[K, V]{N: order, compare: compare, factory: factory, root: root}
+	self.size = self.total(self.load(root))
+	self.ends()
+	return self
+}
+
+// Make the changes to the tree durable when its factory is a Committer
+func (self *BPlusTree[K, V]) Commit() error {
+	if c, ok := self.factory.(Committer[K, V]); ok {
+		if _, ok := self.root.(bare[K, V]); ok {
+			return c.Commit(nil)
+		}
+		return c.Commit(self.root)
+	}
+	return nil
+}
+
+// The first error of the node factory reading or writing a node. Changes to
+// the tree are refused once there is one, reads go on but may miss entries.
+func (self *BPlusTree[K, V]) Err() error {
+	if f, ok := self.factory.(failer); ok {
+		return f.Err()
+	}
+	return nil
+}
+
+// Fetch by key, returns the zero value when the key is not present
+func (self *BPlusTree[K, V]) Get(key K) V {
+	value, _ := self.GetOK(key)
+	return value
+}
+
+// Fetch by key, ok reports whether the key is present
+func (self *BPlusTree[K, V]) GetOK(key K) (value V, ok bool) {
+	var recurse func (Node[K, V]) (V, bool)
+	recurse = func(n Node[K, V]) (V, bool) {
+		pos, match := n.Find(key)
 		if n.isLeaf() {
-			return next
+			if match {
+				return n.Value(pos), true
+			}
+			var none V
+			return none, false
 		}
-		return recurse(next.(Node))
+		return recurse(n.Child(pos))
 	}
 	return recurse(self.root)
 }
 
+// True when the key is present
+func (self *BPlusTree[K, V]) Has(key K) bool {
+	_, ok := self.GetOK(key)
+	return ok
+}
+
 // Insert a key/value
-func (self *BPlusTree) Put(key interface{}, value interface{}) {
-	var recurse func(Node) (* MemNode, *Entry)
-	recurse = func (n Node) (* MemNode, *Entry) {
-		pos, match, next := n.Find(key)
-		var temp_value * Entry = nil
-		var temp_node * MemNode = nil
+func (self *BPlusTree[K, V]) Put(key K, value V) {
+	self.Swap(key, value)
+}
+
+// Insert a key/value, returning the value it replaced
+func (self *BPlusTree[K, V]) Swap(key K, value V) (old V, replaced bool) {
+	old, replaced, _ = self.modify(key, func(V, bool) (V, bool) {
+		return value, true
+	})
+	return
+}
+
+// Store the value returned by fn, which is passed the current value of key
+// and whether the key is present. Returns the stored value.
+func (self *BPlusTree[K, V]) Upsert(key K, fn func(old V, exists bool) V) V {
+	_, _, value := self.modify(key, func(old V, exists bool) (V, bool) {
+		return fn(old, exists), true
+	})
+	return value
+}
+
+// Replace the value of a present key with the value returned by fn. Returns
+// the stored value and whether the key was present.
+func (self *BPlusTree[K, V]) Update(key K, fn func(old V) V) (V, bool) {
+	_, exists, value := self.modify(key, func(old V, exists bool) (V, bool) {
+		if ! exists {
+			return old, false
+		}
+		return fn(old), true
+	})
+	return value, exists
+}
+
+// Insert a key/value unless the key is present. Returns the value in the tree
+// and whether it was inserted.
+func (self *BPlusTree[K, V]) PutIfAbsent(key K, value V) (V, bool) {
+	_, exists, value := self.modify(key, func(old V, exists bool) (V, bool) {
+		if exists {
+			return old, false
+		}
+		return value, true
+	})
+	return value, ! exists
+}
+
+// Replace the value of key with new if the key is present with the value old
+func CompareAndSwap[K any, V comparable](tree *BPlusTree[K, V], key K, old, new V) bool {
+	swapped := false
+	tree.modify(key, func(current V, exists bool) (V, bool) {
+		if ! exists || current != old {
+			return current, false
+		}
+		swapped = true
+		return new, true
+	})
+	return swapped
+}
+
+// Descend once to the leaf for key and store the value returned by fn unless
+// fn declines to write. Returns the previous value, whether the key was 
+// present and the value the key has afterwards. Nothing is written when a
+// node on the way can't be read.
+func (self *BPlusTree[K, V]) modify(key K, fn func(V, bool) (V, bool)) (old V, replaced bool, result V) {
+	if self.Err() != nil {
+		return
+	}
+	self.plant()
+	var recurse func(Node[K, V]) (* MemNode[K, V], *Entry[K, V], bool)
+	recurse = func (n Node[K, V]) (* MemNode[K, V], *Entry[K, V], bool) {
+		pos, match := n.Find(key)
+		var temp_value * Entry[K, V] = nil
+		var temp_node * MemNode[K, V] = nil
+		var grew bool
 		
 		if n.isLeaf() {
-			temp_value = &Entry{key, value}
+			if self.Err() != nil {
+				return nil, nil, false
+			}
+			if match {
+				old, replaced = n.Value(pos), true
+			}
+			value, write := fn(old, replaced)
+			result = value
+			if write {
+				temp_value = &Entry[K, V]{key, value}
+				grew = ! match
+			}
 		}else{
-			temp_node, temp_value = recurse(next.(Node))
+			temp_node, temp_value, grew = recurse(n.Child(pos))
 		}
 
-		if temp_value != nil {
+		if temp_value != nil || grew {
 			me := self.load(n)
 			defer self.store(me)
-			if ! match {
+			if grew && me.Counts != nil {
+				me.Counts[pos]++
+			}
+			if temp_value == nil {
+				// only the count changed
+			}else if match && n.isLeaf() {
+				// replace, a match in an internal node is only a separator
+				me.Entries[pos-1] = *temp_value
+			}else{
 				self.insert(pos, me, temp_value, temp_node)
 				if len(me.Entries) > self.N {
-					return self.split(me)
+					node, median := self.split(me)
+					return node, median, grew
 				}
-			}else{
-				// replace
-				me.Entries[pos-1] = *temp_value
 			}
 		}
 		
-		return nil, nil
+		return nil, nil, grew
 	}	
-	node, median := recurse(self.root)
+	node, median, grew := recurse(self.root)
+	if grew {
+		self.size++
+	}
 	if node != nil {
-		newroot := new (MemNode)
+		newroot := new (MemNode[K, V])
 		newroot.ref = self.factory.NewNode(self.N)
-		newroot.Entries = make([] Entry, 1, 1)
-		newroot.Nodes = make([] Node, 2, 2)
+		newroot.Entries = make([] Entry[K, V], 1, 1)
+		newroot.Nodes = make([] Node[K, V], 2, 2)
+		newroot.Counts = make([] int, 2, 2)
 		newroot.Entries[0] = *median
 		newroot.Nodes[0] = self.root
 		newroot.Nodes[1] = node.ref
+		newroot.Counts[1] = self.total(node)
+		newroot.Counts[0] = self.size - newroot.Counts[1]
 		self.store(newroot)
 		self.root = newroot.ref		
 	}
+	return
+}
+
+// Remove a key, returning the value it had
+func (self *BPlusTree[K, V]) Delete(key K) (old V, found bool) {
+	removed, found := self.remove(func (n Node[K, V]) (int, bool) {
+		return n.Find(key)
+	})
+	return removed.Value, found
+}
+
+// Remove and return the entry with the smallest key
+func (self *BPlusTree[K, V]) PopMin() (K, V, bool) {
+	removed, found := self.remove(func (n Node[K, V]) (int, bool) {
+		if n.isLeaf() {
+			return 1, n.Size() > 0
+		}
+		return 0, false
+	})
+	return removed.Key, removed.Value, found
+}
+
+// Remove and return the entry with the largest key
+func (self *BPlusTree[K, V]) PopMax() (K, V, bool) {
+	removed, found := self.remove(func (n Node[K, V]) (int, bool) {
+		return n.Size(), n.isLeaf() && n.Size() > 0
+	})
+	return removed.Key, removed.Value, found
+}
+
+// Remove and return up to count entries with the smallest keys. The leading
+// leaves are cut off together by DeleteRange, which rebalances once.
+func (self *BPlusTree[K, V]) DeleteMin(count int) [] Entry[K, V] {
+	rval := make ([] Entry[K, V], 0, min(count, self.size))
+	if self.Err() != nil {
+		return rval
+	}
+	for key, value := range self.All() {
+		if len(rval) == count {
+			break
+		}
+		rval = append(rval, Entry[K, V]{key, value})
+	}
+	if len(rval) > 0 {
+		self.DeleteRange(Unbounded[K](), Inclusive(rval[len(rval)-1].Key))
+	}
+	return rval
 }
 
-func (self *BPlusTree) Delete(key interface{}) {
-	var del func (n Node) * MemNode
-	del = func(n Node) (temp * MemNode) {
-		pos, match, next := n.Find(key)
+// Descend the path picked by choose, which works like Node.Find, and remove
+// the entry it matches in a leaf. Nothing is removed when a node on the way
+// can't be read.
+func (self *BPlusTree[K, V]) remove(choose func (Node[K, V]) (int, bool)) (removed Entry[K, V], found bool) {
+	if self.Err() != nil {
+		return
+	}
+	var del func (n Node[K, V]) * MemNode[K, V]
+	del = func(n Node[K, V]) (temp * MemNode[K, V]) {
+		pos, match := choose(n)
 		if n.isLeaf() {
 			// (leaf node) Kill the entry 
-			if match {
+			if match && self.Err() == nil {
 				temp = self.load(n)
+				removed, found = temp.Entries[pos-1], true
 				copy(temp.Entries[pos-1:], temp.Entries[pos:])
 				temp.Entries = temp.Entries[0:len(temp.Entries)-1]
 			}
 		}else{
-			updated := del(next.(Node))
+			updated := del(n.Child(pos))
 			if updated != nil {
+				temp = self.load(n)
+				temp.Counts[pos]--
 				if len(updated.Entries) < self.N/2 {
-					temp = self.load(n)
 					self.pivot(pos, updated, temp)
 				}else{
 					self.store(updated)
@@ -111,10 +324,11 @@ func (self *BPlusTree) Delete(key interface{}) {
 	}
 	modified := del(self.root)
 	if modified != nil {
+		self.size--
 		// Root was modified
 		if modified.Nodes != nil {
 			if len(modified.Nodes) == 1 {
-				self.root = modified.Nodes[0].(Node)
+				self.root = modified.Nodes[0]
 				self.factory.Release(modified.ref)
 			}else{
 				self.store(modified)
@@ -123,32 +337,21 @@ func (self *BPlusTree) Delete(key interface{}) {
 			self.store(modified)
 		}
 	}
+	return
 }
 
-func (self *BPlusTree) Check(t *testing.T)  {
-/*
-	//fmt.Println("-- BEGIN TREE CHECK --")
-	var checker func (n Node)
-	checker = func (n Node) {
-		//fmt.Println("check:", n)
-		if ! n.isLeaf() {
-			temp := self.load(n)
-			for _,k := range temp.Nodes {
-				checker(k)
-			}
-		}
-		size := n.Size()
-		if (size < self.N/2 && n != self.root) || size > self.N {
-			t.Error("Check: Node size:", size, n)
-			t.FailNow()
-		}
-	}
-	checker(self.root)
-*/
+// The number of entries in the tree
+func (self *BPlusTree[K, V]) Len() int {
+	return self.size
 }
 
-func (self *BPlusTree) Iterate() chan Entry {
-	ch := make (chan Entry)
+// Stream all entries in ascending order. The goroutine that feeds the channel
+// only exits after the last entry is read.
+//
+// Deprecated: a caller that stops reading leaks the goroutine, range over All
+// or use IterateContext instead.
+func (self *BPlusTree[K, V]) Iterate() chan Entry[K, V] {
+	ch := make (chan Entry[K, V])
 	
 	go func() {
 		for working := self.head; working != nil; working = working.Next() {
@@ -159,7 +362,7 @@ func (self *BPlusTree) Iterate() chan Entry {
 	return ch
 }
 
-func (self *BPlusTree) insert (pos int, node *MemNode, value *Entry, link *MemNode) {
+func (self *BPlusTree[K, V]) insert (pos int, node *MemNode[K, V], value *Entry[K, V], link *MemNode[K, V]) {
 	max := len(node.Entries)
 	node.Entries = append(node.Entries, *value)
 	if (pos < max) {
@@ -168,48 +371,70 @@ func (self *BPlusTree) insert (pos int, node *MemNode, value *Entry, link *MemNo
 	}
 
 	if (link != nil) {
+		count := self.total(link)
+		node.Counts[pos] -= count
 		pos = pos + 1
 		node.Nodes = append (node.Nodes, link.ref)
+		node.Counts = append (node.Counts, count)
 		if (pos < max + 1) {
 			copy(node.Nodes[pos+1:], node.Nodes[pos:])
 			node.Nodes[pos] = link.ref
+			copy(node.Counts[pos+1:], node.Counts[pos:])
+			node.Counts[pos] = count
 		}
 	}
 }
 
-func (self *BPlusTree) split (node *MemNode) (*MemNode, *Entry) {
-	var rnode *MemNode 
+// The number of entries under a node
+func (self *BPlusTree[K, V]) total(m *MemNode[K, V]) (count int) {
+	if m.Counts == nil {
+		return len(m.Entries)
+	}
+	for _, c := range m.Counts {
+		count += c
+	}
+	return
+}
+
+func (self *BPlusTree[K, V]) split (node *MemNode[K, V]) (*MemNode[K, V], *Entry[K, V]) {
+	var rnode *MemNode[K, V] 
 	median := node.Entries[self.N/2]
 
-	rnode = new(MemNode)
+	rnode = new(MemNode[K, V])
 	if node.ref.isLeaf() {
 		rnode.ref = self.factory.NewLeaf(self.N)
 
-		rnode.Entries = make ([] Entry, 0, self.N)	
+		rnode.Entries = make ([] Entry[K, V], 0, self.N)	
 		rnode.Entries = append(rnode.Entries, node.Entries[self.N/2:]...)
 		node.Entries = node.Entries[0:self.N/2]
 
 		rnode.neighbor = node.neighbor
+		rnode.previous = node.ref
 		node.neighbor = rnode.ref
+		self.relink(rnode)
 	}else{
 		rnode.ref = self.factory.NewNode(self.N)
 
-		rnode.Entries = make ([] Entry, 0, self.N)	
+		rnode.Entries = make ([] Entry[K, V], 0, self.N)	
 		rnode.Entries = append(rnode.Entries, node.Entries[self.N/2+1:]...)
 		node.Entries = node.Entries[0:self.N/2]
 
-		rnode.Nodes = make ([] Node, 0, self.N+2)
+		rnode.Nodes = make ([] Node[K, V], 0, self.N+2)
 		rnode.Nodes = append(rnode.Nodes, node.Nodes[self.N/2+1:]...)
 		node.Nodes = node.Nodes[0:self.N/2+1]
+
+		rnode.Counts = make ([] int, 0, self.N+2)
+		rnode.Counts = append(rnode.Counts, node.Counts[self.N/2+1:]...)
+		node.Counts = node.Counts[0:self.N/2+1]
 	}
 
 	self.store(rnode)	
 	return rnode, &median;
 }
 
-func (self *BPlusTree) pivot(pos int, child *MemNode, root *MemNode) {
+func (self *BPlusTree[K, V]) pivot(pos int, child *MemNode[K, V], root *MemNode[K, V]) {
 	left := 0; right := 1
-	var leftnode, rightnode *MemNode 
+	var leftnode, rightnode *MemNode[K, V] 
 	if pos > 0 {
 		left = pos - 1
 		right = pos
@@ -219,24 +444,32 @@ func (self *BPlusTree) pivot(pos int, child *MemNode, root *MemNode) {
 		leftnode = child
 		rightnode = self.load(root.Nodes[right])
 	}
+	if self.Err() != nil {
+		// A sibling that can't be read is left as it is
+		return
+	}
 
 	// Join neighbors...	
-	joined := new (MemNode)
+	joined := new (MemNode[K, V])
 	joined.ref = leftnode.ref
 	joined.neighbor = rightnode.neighbor
+	joined.previous = leftnode.previous
 			
 	if leftnode.Nodes != nil {
 		// Node join
-		joined.Nodes = make ([] Node, 0, self.N+1)
+		joined.Nodes = make ([] Node[K, V], 0, self.N+1)
 		joined.Nodes = append(joined.Nodes, leftnode.Nodes...)
 		joined.Nodes = append(joined.Nodes, rightnode.Nodes...)
-		joined.Entries = make ([] Entry, 0, self.N)
+		joined.Counts = make ([] int, 0, self.N+1)
+		joined.Counts = append(joined.Counts, leftnode.Counts...)
+		joined.Counts = append(joined.Counts, rightnode.Counts...)
+		joined.Entries = make ([] Entry[K, V], 0, self.N)
 		joined.Entries = append(joined.Entries, leftnode.Entries...)
 		joined.Entries = append(joined.Entries, root.Entries[left])
 		joined.Entries = append(joined.Entries, rightnode.Entries...)
 	}else{
 		// Leaf join
-		joined.Entries = make ([] Entry, 0, self.N)
+		joined.Entries = make ([] Entry[K, V], 0, self.N)
 		joined.Entries = append(joined.Entries, leftnode.Entries...)
 		joined.Entries = append(joined.Entries, rightnode.Entries...)
 	}
@@ -247,9 +480,11 @@ func (self *BPlusTree) pivot(pos int, child *MemNode, root *MemNode) {
 		newnode, newmedian := self.split(joined)
 		root.Nodes[right] = newnode.ref
 		root.Entries[left] = *newmedian
+		root.Counts[left] = self.total(joined)
+		root.Counts[right] = self.total(newnode)
 				
 		self.store(joined)
-		self.store(rightnode)
+		self.factory.Release(rightnode.ref)
 
 	} else {
 		// Pivot results in one node
@@ -259,23 +494,48 @@ func (self *BPlusTree) pivot(pos int, child *MemNode, root *MemNode) {
 		copy(root.Nodes[left:], root.Nodes[left+1:])
 		root.Nodes = root.Nodes[0:len(root.Nodes)-1]
 		root.Nodes[left] = joined.ref
+
+		copy(root.Counts[left:], root.Counts[left+1:])
+		root.Counts = root.Counts[0:len(root.Counts)-1]
+		root.Counts[left] = self.total(joined)
 		
+		if joined.Nodes == nil {
+			self.relink(joined)
+		}
 		self.store(joined)
 		self.factory.Release(rightnode.ref)		
 	}
 }
 
-func (self * BPlusTree) load(t Node) * MemNode {
-	rval := new (MemNode)
-	rval.Entries = make ([] Entry, 0, self.N)
+// Point the leaf after m back at m
+func (self *BPlusTree[K, V]) relink(m *MemNode[K, V]) {
+	if m.neighbor == nil {
+		self.tail = m.ref
+		return
+	}
+	next := self.load(m.neighbor)
+	next.previous = m.ref
+	self.store(next)
+}
+
+func (self * BPlusTree[K, V]) load(t Node[K, V]) * MemNode[K, V] {
+	rval := self.blank(t)
+	t.Load(rval)
+	return rval
+}
+
+// An empty MemNode for t, used for nodes that were never stored
+func (self * BPlusTree[K, V]) blank(t Node[K, V]) * MemNode[K, V] {
+	rval := new (MemNode[K, V])
+	rval.Entries = make ([] Entry[K, V], 0, self.N)
 	if ! t.isLeaf() {
-		rval.Nodes = make([] Node, 0, self.N+1)
+		rval.Nodes = make([] Node[K, V], 0, self.N+1)
+		rval.Counts = make([] int, 0, self.N+1)
 	}
 	rval.ref = t
-	t.Load(rval)
 	return rval
 }
 
-func (self * BPlusTree) store(m *MemNode) {
+func (self * BPlusTree[K, V]) store(m *MemNode[K, V]) {
 	m.ref.Store(m)
 }
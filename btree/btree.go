@@ -2,12 +2,13 @@ package btree
 
 import (
 //	"fmt"
-	"testing"
+	"cmp"
 )
 
-type BTree struct {
+type BTree[K any, V any] struct {
 	N int
-	root * bNode
+	root * bNode[K, V]
+	compare func(a, b K) int
 	Stats struct {
 		Size int
 		Depth int
@@ -16,28 +17,28 @@ type BTree struct {
 	}
 }
 
-type Pair struct {
-	key uint64
-	value interface{}
+type Pair[K any, V any] struct {
+	key K
+	value V
 }
 
-func (self Pair) Key() uint64 {
+func (self Pair[K, V]) Key() K {
 	return self.key
 }
 
-func (self Pair) Value() interface{} {
+func (self Pair[K, V]) Value() V {
 	return self.value
 }
 
-type bNode struct {
-	Values [] Pair
-	Nodes [] *bNode
+type bNode[K any, V any] struct {
+	Values [] Pair[K, V]
+	Nodes [] *bNode[K, V]
 }
 
-func nodeFind (node *bNode, value uint64) int {
+func (tree *BTree[K, V]) nodeFind (node *bNode[K, V], value K) int {
 	pos := len(node.Values)
 	for i, k := range node.Values {
-		if value < k.key {
+		if tree.compare(value, k.key) < 0 {
 			pos = i;
 			break
 		}
@@ -45,17 +46,17 @@ func nodeFind (node *bNode, value uint64) int {
 	return pos
 }
 
-func (tree *BTree) split (self *bNode) (* bNode, Pair) {
-	var rnode *bNode 
+func (tree *BTree[K, V]) split (self *bNode[K, V]) (* bNode[K, V], Pair[K, V]) {
+	var rnode *bNode[K, V] 
 	median := self.Values[tree.N/2]	
 
-	rnode = new(bNode)
-	rnode.Values = make ([] Pair, 0, tree.N+1)
+	rnode = new(bNode[K, V])
+	rnode.Values = make ([] Pair[K, V], 0, tree.N+1)
 	rnode.Values = append(rnode.Values, self.Values[tree.N/2+1:]...)
 	self.Values = self.Values[0:tree.N/2]
 
 	if self.Nodes != nil {
-		rnode.Nodes = make ([] * bNode, 0, tree.N+2)
+		rnode.Nodes = make ([] * bNode[K, V], 0, tree.N+2)
 		rnode.Nodes = append(rnode.Nodes, self.Nodes[tree.N/2+1:]...)
 		self.Nodes = self.Nodes[0:tree.N/2+1]
 		tree.Stats.Nodes++
@@ -69,10 +70,10 @@ func (tree *BTree) split (self *bNode) (* bNode, Pair) {
 	return rnode, median;
 }
 
-func (tree *BTree) valueInsert (pos int, self * bNode, value *Pair, link *bNode) (* bNode, Pair) {
+func (tree *BTree[K, V]) valueInsert (pos int, self * bNode[K, V], value *Pair[K, V], link *bNode[K, V]) (* bNode[K, V], Pair[K, V]) {
 	max := len(self.Values)
 
-	if pos > 0 && self.Values[pos-1].Key() == value.Key() {
+	if pos > 0 && tree.compare(self.Values[pos-1].Key(), value.Key()) == 0 {
 		self.Values[pos-1] = *value
 	}else{
 		self.Values = append (self.Values, *value)
@@ -96,74 +97,113 @@ func (tree *BTree) valueInsert (pos int, self * bNode, value *Pair, link *bNode)
 		return tree.split(self)
 	}
 	
-	return nil, Pair{};
+	return nil, Pair[K, V]{};
 }
 
-func (tree * BTree) insert (self *bNode, value *Pair) (*bNode, Pair) {
-	var rnode * bNode = nil
-	var rval Pair
+// Returns the split node and median, plus the replaced pair when the key was present
+func (tree * BTree[K, V]) insert (self *bNode[K, V], value *Pair[K, V]) (*bNode[K, V], Pair[K, V], *Pair[K, V]) {
+	var rnode * bNode[K, V] = nil
+	var rval Pair[K, V]
+	var replaced * Pair[K, V] = nil
 	
-	pos := nodeFind(self, value.key)
+	pos := tree.nodeFind(self, value.key)
 
-	if self.Nodes != nil {
-		node, median := tree.insert(self.Nodes[pos], value)
+	if pos > 0 && tree.compare(self.Values[pos-1].key, value.key) == 0 {
+		// Replace in place, the key can't also be in a subtree
+		old := self.Values[pos-1]
+		self.Values[pos-1] = *value
+		replaced = &old
+	}else if self.Nodes != nil {
+		var node * bNode[K, V]
+		var median Pair[K, V]
+		node, median, replaced = tree.insert(self.Nodes[pos], value)
 		if node != nil {
 			rnode, rval = tree.valueInsert(pos, self, &median, node)
 		}
 	}else{
 		rnode, rval = tree.valueInsert(pos, self, value, nil)
 	}
-	return rnode, rval
+	return rnode, rval, replaced
+}
+
+// Insert a key/value
+func (tree * BTree[K, V]) Put (index K, value V) {
+	tree.Swap(index, value)
 }
 
-func (tree * BTree) Put (index interface{}, value interface{}) {
-	node, median := tree.insert(tree.root, &Pair{index.(uint64), value})
+// Insert a key/value, returning the value it replaced
+func (tree * BTree[K, V]) Swap (index K, value V) (old V, replaced bool) {
+	node, median, prev := tree.insert(tree.root, &Pair[K, V]{index, value})
 	if node != nil {
-		n := new(bNode)
-		n.Values = make ([] Pair, 1, tree.N+1)
-		n.Nodes = make ([] * bNode, 2, tree.N+2)
+		n := new(bNode[K, V])
+		n.Values = make ([] Pair[K, V], 1, tree.N+1)
+		n.Nodes = make ([] * bNode[K, V], 2, tree.N+2)
 		n.Values[0] = median
 		n.Nodes[0] = tree.root
 		n.Nodes[1] = node
 		tree.root = n
 		tree.Stats.Depth++
+		tree.Stats.Nodes++
+	}
+	if prev != nil {
+		return prev.value, true
 	}
 	tree.Stats.Size++	
+	return
 }
 
-func (tree * BTree) fetch (index uint64, node *bNode) (interface{}) {
-	pos := nodeFind(node, index)
+func (tree * BTree[K, V]) fetch (index K, node *bNode[K, V]) (V, bool) {
+	pos := tree.nodeFind(node, index)
 
-	if pos > 0 && node.Values[pos-1].key == index {
-		return node.Values[pos-1].Value
+	if pos > 0 && tree.compare(node.Values[pos-1].key, index) == 0 {
+		return node.Values[pos-1].value, true
 	}
 	
 	if node.Nodes != nil {
 		return tree.fetch(index, node.Nodes[pos])
 	}
 
-	return nil
+	var none V
+	return none, false
 }
 
-func (tree * BTree) Get (index interface{}) (value interface{}) {
-	return tree.fetch(index.(uint64), tree.root)
+// Fetch by key, returns the zero value when the key is not present
+func (tree * BTree[K, V]) Get (index K) (value V) {
+	value, _ = tree.fetch(index, tree.root)
+	return
 }
 
-func (tree * BTree) Iterate() chan Entry {
-	var spilunk func (n *bNode)
-	ch := make (chan Entry)
+// Fetch by key, ok reports whether the key is present
+func (tree * BTree[K, V]) GetOK (index K) (value V, ok bool) {
+	return tree.fetch(index, tree.root)
+}
+
+// True when the key is present
+func (tree * BTree[K, V]) Has (index K) bool {
+	_, ok := tree.fetch(index, tree.root)
+	return ok
+}
 
-	spilunk = func (n *bNode) {
+// Stream all entries in ascending order. The goroutine that feeds the channel
+// only exits after the last entry is read.
+//
+// Deprecated: a caller that stops reading leaks the goroutine, range over All
+// or use IterateContext instead.
+func (tree * BTree[K, V]) Iterate() chan Entry[K, V] {
+	var spilunk func (n *bNode[K, V])
+	ch := make (chan Entry[K, V])
+
+	spilunk = func (n *bNode[K, V]) {
 		if n.Nodes != nil {
 			for i,next := range n.Nodes {
 				spilunk(next)
 				if i < len(n.Values) {
-					ch <- Entry{n.Values[i].Key(), n.Values[i].Value()}
+					ch <- Entry[K, V]{n.Values[i].Key(), n.Values[i].Value()}
 				}
 			} 
 		}else{
 			for _,next := range n.Values {
-				ch <- Entry{next.Key(), next.Value()}
+				ch <- Entry[K, V]{next.Key(), next.Value()}
 			}
 		}
 	}		
@@ -174,7 +214,7 @@ func (tree * BTree) Iterate() chan Entry {
 	return ch
 }
 
-func (tree * BTree) balance (parent *bNode, pos int) {
+func (tree * BTree[K, V]) balance (parent *bNode[K, V], pos int) {
 
 	var left, right int	
 	if pos == 0 {
@@ -186,16 +226,16 @@ func (tree * BTree) balance (parent *bNode, pos int) {
 	}
 	
 	// Join neighbors...	
-	joined := new (bNode)
+	joined := new (bNode[K, V])
 	leftnode := parent.Nodes[left]
 	rightnode := parent.Nodes[right]
 		
 	if leftnode.Nodes != nil {
-		joined.Nodes = make ([] *bNode, 0, tree.N+2)
+		joined.Nodes = make ([] *bNode[K, V], 0, tree.N+2)
 		joined.Nodes = append(joined.Nodes, leftnode.Nodes...)
 		joined.Nodes = append(joined.Nodes, rightnode.Nodes...)
 	}
-	joined.Values = make ([] Pair, 0, tree.N+1)
+	joined.Values = make ([] Pair[K, V], 0, tree.N+1)
 	joined.Values = append(joined.Values, leftnode.Values...)
 	joined.Values = append(joined.Values, parent.Values[left])
 	joined.Values = append(joined.Values, rightnode.Values...)
@@ -212,17 +252,18 @@ func (tree * BTree) balance (parent *bNode, pos int) {
 		copy(parent.Nodes[left:], parent.Nodes[left+1:])
 		parent.Nodes = parent.Nodes[0:len(parent.Nodes)-1]
 		parent.Nodes[left] = joined
+	}
 
-		if joined.Nodes == nil {
-			tree.Stats.Leaves--
-		}else{
-			tree.Stats.Nodes--
-		}
+	// The right node is gone, when the balance split joined it counted the new one
+	if joined.Nodes == nil {
+		tree.Stats.Leaves--
+	}else{
+		tree.Stats.Nodes--
 	}
 }
 
-func (tree * BTree) borrow (node *bNode) (Pair, int) {
-	var rvalue Pair
+func (tree * BTree[K, V]) borrow (node *bNode[K, V]) (Pair[K, V], int) {
+	var rvalue Pair[K, V]
 	if node.Nodes != nil {
 		// Keep descending
 		last := len(node.Nodes) - 1
@@ -241,12 +282,36 @@ func (tree * BTree) borrow (node *bNode) (Pair, int) {
 	return rvalue, len(node.Values);
 }
 
-func (tree * BTree) del (index uint64, node *bNode) int {
-	pos := nodeFind(node, index)
+// Remove the first value under node, the mirror image of borrow
+func (tree * BTree[K, V]) borrowFirst (node *bNode[K, V]) (Pair[K, V], int) {
+	var rvalue Pair[K, V]
+	if node.Nodes != nil {
+		// Keep descending
+		borrow, remaining := tree.borrowFirst(node.Nodes[0])
+		if remaining < (tree.N/2) {
+			// Under threshold, must balance
+			tree.balance(node, 0)
+		}
+		rvalue = borrow
+	} else{
+		// Borrow first value
+		rvalue = node.Values[0]
+		copy(node.Values, node.Values[1:])
+		node.Values = node.Values[0:len(node.Values)-1]
+	}	
+	return rvalue, len(node.Values);
+}
+
+// Returns the remaining number of values and the removed pair, if any
+func (tree * BTree[K, V]) del (index K, node *bNode[K, V]) (int, *Pair[K, V]) {
+	pos := tree.nodeFind(node, index)
+	var removed * Pair[K, V] = nil
 	
-	if pos > 0 && node.Values[pos-1].key == index {
+	if pos > 0 && tree.compare(node.Values[pos-1].key, index) == 0 {
 		// Found the delete value
 		tree.Stats.Size--
+		old := node.Values[pos-1]
+		removed = &old
 		if node.Nodes != nil {
 			// Lost median, must borrow
 			var remaining int
@@ -261,28 +326,87 @@ func (tree * BTree) del (index uint64, node *bNode) int {
 		}
 	} else if node.Nodes != nil {	
 		// Value not found... descend
-		remaining := tree.del(index, node.Nodes[pos])
+		var remaining int
+		remaining, removed = tree.del(index, node.Nodes[pos])
 		if remaining < (tree.N/2) {
 			// Under threshold, must balance
 			tree.balance(node, pos)
 		}		
 	}
 
-	return len(node.Values)
+	return len(node.Values), removed
 }
 
-func (tree *BTree) Delete(key interface{}) {
-	if (tree.del(key.(uint64), tree.root) == 0 && tree.root.Nodes != nil) {
+// Remove a key, returning the value it had
+func (tree *BTree[K, V]) Delete(key K) (old V, found bool) {
+	remaining, removed := tree.del(key, tree.root)
+	if (remaining == 0 && tree.root.Nodes != nil) {
 		tree.root = tree.root.Nodes[0]
 		tree.Stats.Depth--
+		tree.Stats.Nodes--
+	}
+	if removed != nil {
+		return removed.value, true
+	}
+	return
+}
+
+// Remove and return the entry with the smallest key
+func (tree *BTree[K, V]) PopMin() (K, V, bool) {
+	return tree.pop(tree.borrowFirst)
+}
+
+// Remove and return the entry with the largest key
+func (tree *BTree[K, V]) PopMax() (K, V, bool) {
+	return tree.pop(tree.borrow)
+}
+
+// Remove and return up to count entries with the smallest keys
+func (tree *BTree[K, V]) DeleteMin(count int) [] Entry[K, V] {
+	rval := make ([] Entry[K, V], 0, min(count, tree.Stats.Size))
+	for len(rval) < count {
+		key, value, ok := tree.PopMin()
+		if ! ok {
+			break
+		}
+		rval = append(rval, Entry[K, V]{key, value})
 	}
+	return rval
 }
 
-func NewBTree(order int) *BTree {
-	tree := new (BTree)
+// Remove an extreme value with borrow or borrowFirst
+func (tree *BTree[K, V]) pop(take func (*bNode[K, V]) (Pair[K, V], int)) (key K, value V, ok bool) {
+	if tree.Stats.Size == 0 {
+		return
+	}
+	removed, remaining := take(tree.root)
+	tree.Stats.Size--
+	if (remaining == 0 && tree.root.Nodes != nil) {
+		tree.root = tree.root.Nodes[0]
+		tree.Stats.Depth--
+		tree.Stats.Nodes--
+	}
+	return removed.key, removed.value, true
+}
+
+// The number of entries in the tree
+func (tree *BTree[K, V]) Len() int {
+	return tree.Stats.Size
+}
+
+// Initialize a tree ordered by the natural order of its keys
+func NewBTree[K cmp.Ordered, V any](order int) *BTree[K, V] {
+	return NewBTreeFunc[K, V](order, cmp.Compare[K])
+}
+
+// Initialize a tree ordered by compare, which returns a negative number,
+// zero or a positive number when a < b, a == b or a > b (see cmp.Compare)
+func NewBTreeFunc[K any, V any](order int, compare func(a, b K) int) *BTree[K, V] {
+	tree := new (BTree[K, V])
 	tree.N = order
-	tree.root = new(bNode)
-	tree.root.Values = make ([] Pair, 0, tree.N+1)
+	tree.compare = compare
+	tree.root = new(bNode[K, V])
+	tree.root.Values = make ([] Pair[K, V], 0, tree.N+1)
 	tree.root.Nodes = nil
 	tree.Stats.Leaves = 1
 	tree.Stats.Nodes = 0
@@ -290,5 +414,3 @@ func NewBTree(order int) *BTree {
 	return tree
 }
 
-func (tree *BTree) Check(t *testing.T) {
-}
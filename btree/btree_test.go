@@ -2,335 +2,1813 @@ package btree
 
 import (
     "testing"
-	"runtime"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"iter"
+	"maps"
+	"math"
 	"time"
 	"math/rand"
-	"github.com/cznic/b"
+	"slices"
+	"strings"
 )
 
-type CznicAdapter struct {
-	tree *b.Tree	
-}
 
-func cmp(a, b uint64) int {
-	if a < b {
-		return -1
-	}else if (a > b) {
-		return 1
-	}else{
-		return 0
+func StringTest(t *testing.T, tree Treelike[string, string]) {
+	words := [] string {"pear", "apple", "fig", "kiwi", "banana", "cherry", "date", "grape", "lime"}
+	for _,w := range words {
+		tree.Put(w, w + "!")
+	}
+	tree.Delete("fig")
+	
+	for _,w := range words {
+		value := tree.Get(w)
+		if w == "fig" {
+			if value != "" {
+				t.Error("Get(): Deleted key is present:", w)
+			}
+		}else if value != w + "!" {
+			t.Error("Get(): Mismatch:", value, "!=", w + "!")
+		}
+	}
+
+	last := ""
+	for entry := range tree.Iterate() {
+		if entry.Key <= last {
+			t.Error("Iterate(): Values are not increasing:", entry.Key, "<=", last)
+		}
+		last = entry.Key
 	}
 }
 
-func CznicTree() *CznicAdapter {
-	rval := new (CznicAdapter)
-	rval.tree = b.TreeNew(cmp)
-	return rval
+func TestStringKeys(t *testing.T) {
+	StringTest(t, NewBPlusTree[string, string](4))
 }
-	
-func (self *CznicAdapter) Put(key interface{}, value interface{}) {
-	self.tree.Set(key.(uint64), value.(int))
+
+func TestStringKeysBTree(t *testing.T) {
+	StringTest(t, NewBTree[string, string](4))
+}
+
+type stamp struct {
+	seconds int64
+	sequence int
 }
 
-func (self *CznicAdapter) Get(key interface{}) interface{} {
-	rval,okay := self.tree.Get(key.(uint64)) 
-	if okay {
-		return rval
-	}else{
-		return nil
+func TestCompositeKeys(t *testing.T) {
+	compare := func(a, b stamp) int {
+		if a.seconds != b.seconds {
+			return cmp.Compare(a.seconds, b.seconds)
+		}
+		return cmp.Compare(a.sequence, b.sequence)
+	}
+	tree := NewBPlusTreeFunc[stamp, int](8, compare)
+	for i:=0; i<1000; i++ {
+		tree.Put(stamp{int64(i % 10), i}, i)
+	}
+	
+	var last * stamp
+	count := 0
+	for entry := range tree.Iterate() {
+		if last != nil && compare(*last, entry.Key) >= 0 {
+			t.Error("Iterate(): Values are not increasing:", entry.Key, "<=", *last)
+		}
+		if entry.Value != entry.Key.sequence {
+			t.Error("Iterate(): Iteration discovered a false value:", entry.Value)
+		}
+		key := entry.Key
+		last = &key
+		count += 1
+	}
+	if count != 1000 {
+		t.Error("Iterate(): Wrong number of entries:", count)
 	}
 }
 
-func (self *CznicAdapter) Delete(key interface{}) {
-	self.tree.Delete(key.(uint64))
+func TestCursor(t *testing.T) {
+	src := rand.NewSource(time.Now().UnixNano())
+	for _,order := range [] int {4, 8, 32} {
+		tree := NewBPlusTree[uint64, int](order)
+		keys := make ([] uint64, 0, 1000)
+		for j:=0; j<1000; j++ {
+			key := uint64(src.Int63() % 10000) * 2
+			if _, ok := slices.BinarySearch(keys, key); !ok {
+				keys = append(keys, key)
+				slices.Sort(keys)
+			}
+			tree.Put(key, j)
+		}
+		for j:=0; j<700; j++ {
+			index := int(src.Int63() % int64(len(keys)))
+			tree.Delete(keys[index])
+			keys = slices.Delete(keys, index, index+1)
+		}
+
+		c := tree.Cursor()
+		i := 0
+		for ok := c.First(); ok; ok = c.Next() {
+			if c.Key() != keys[i] {
+				t.Fatal("Next(): Mismatch:", c.Key(), "!=", keys[i])
+			}
+			i++
+		}
+		if i != len(keys) || c.Valid() {
+			t.Fatal("Next(): Walked", i, "of", len(keys), "entries")
+		}
+
+		i = len(keys) - 1
+		for ok := c.Last(); ok; ok = c.Prev() {
+			if c.Key() != keys[i] {
+				t.Fatal("Prev(): Mismatch:", c.Key(), "!=", keys[i])
+			}
+			i--
+		}
+		if i != -1 || c.Valid() {
+			t.Fatal("Prev(): Stopped at", i)
+		}
+
+		for j:=0; j<200; j++ {
+			key := uint64(src.Int63() % 20002)
+			index, _ := slices.BinarySearch(keys, key)
+			if ! c.Seek(key) {
+				if index != len(keys) {
+					t.Fatal("Seek(): Missed key:", keys[index])
+				}
+				continue
+			}
+			if c.Key() != keys[index] || c.Value() != tree.Get(keys[index]) {
+				t.Fatal("Seek(): Mismatch:", c.Key(), "!=", keys[index])
+			}
+			if c.Prev() != (index > 0) || (index > 0 && c.Key() != keys[index-1]) {
+				t.Fatal("Seek(): Prev() mismatch at", key)
+			}
+		}
+	}
 }
 
-func (self *CznicAdapter) Iterate() chan Entry {
-	return nil
+type Rangelike interface {
+	Treelike[uint64, int]
+	Range(lo, hi Bound[uint64], fn func(uint64, int) bool)
+	ReverseRange(lo, hi Bound[uint64], fn func(uint64, int) bool)
 }
 
-func (self *CznicAdapter) Check(t *testing.T) {
+func RangeTest(t *testing.T, tree Rangelike, seed int64) {
+	src := rand.NewSource(seed)
+	keys := make ([] uint64, 0, 500)
+	for j:=0; j<500; j++ {
+		key := uint64(src.Int63() % 1000)
+		if _, ok := slices.BinarySearch(keys, key); !ok {
+			keys = append(keys, key)
+			slices.Sort(keys)
+		}
+		tree.Put(key, int(key))
+	}
+
+	bound := func() Bound[uint64] {
+		key := uint64(src.Int63() % 1010)
+		switch src.Int63() % 4 {
+		case 0:
+			return Unbounded[uint64]()
+		case 1:
+			return Exclusive(key)
+		}
+		return Inclusive(key)
+	}
+	
+	for j:=0; j<200; j++ {
+		lo, hi := bound(), bound()
+		limit := 1 + int(src.Int63() % 50)
+		var expect [] uint64
+		for _,k := range keys {
+			if lo.above(cmp.Compare[uint64], k) && hi.below(cmp.Compare[uint64], k) {
+				expect = append(expect, k)
+			}
+		}
+		if len(expect) > limit {
+			expect = expect[:limit]
+		}
+		
+		var got [] uint64
+		tree.Range(lo, hi, func(k uint64, v int) bool {
+			got = append(got, k)
+			return len(got) < limit
+		})
+		if ! slices.Equal(got, expect) {
+			t.Fatal("Range(): Mismatch:", lo, hi, got, "!=", expect)
+		}
+
+		expect = expect[:0]
+		for i := len(keys)-1; i >= 0; i-- {
+			if lo.above(cmp.Compare[uint64], keys[i]) && hi.below(cmp.Compare[uint64], keys[i]) {
+				expect = append(expect, keys[i])
+			}
+		}
+		if len(expect) > limit {
+			expect = expect[:limit]
+		}
+		got = got[:0]
+		tree.ReverseRange(lo, hi, func(k uint64, v int) bool {
+			got = append(got, k)
+			return len(got) < limit
+		})
+		if ! slices.Equal(got, expect) {
+			t.Fatal("ReverseRange(): Mismatch:", lo, hi, got, "!=", expect)
+		}
+	}
 }
 
-type BtreeTest struct {
-	test *testing.T
-	tree Treelike 
-	reference map[uint64] int
+func TestRange(t *testing.T) {
+	seed := time.Now().UnixNano()
+	t.Log("Random seed:", seed)
+	for _,order := range [] int {4, 8, 32} {
+		RangeTest(t, NewBPlusTree[uint64, int](order), seed)
+		RangeTest(t, NewBTree[uint64, int](order), seed)
+	}
 }
 
-func (self *BtreeTest) Put(key interface{}, value interface{}) {
-	self.reference[key.(uint64)] = value.(int)
-	self.tree.Put(key, value)
-	if self.reference[key.(uint64)] != self.tree.Get(key) {
-		self.test.Error("Put(): Mismatch:", self.tree.Get(key), "!=", self.reference[key.(uint64)])
-		self.test.FailNow()
-	} 
-	self.tree.Check(self.test)
+type Seqlike interface {
+	Treelike[uint64, int]
+	All() iter.Seq2[uint64, int]
+	Backward() iter.Seq2[uint64, int]
+	IterateContext(context.Context) <-chan Entry[uint64, int]
 }
 
-func (self *BtreeTest) Get(key interface{}) interface{} {
-	value := self.tree.Get(key)
-	if (value != self.reference[key.(uint64)]) {
-		self.test.Error("Fetch(): Mismatch:", value, "!=", self.reference[key.(uint64)])
+func SeqTest(t *testing.T, tree Seqlike) {
+	for j:=0; j<1000; j++ {
+		tree.Put(uint64(j * 7 % 1000), j)
+	}
+
+	var next uint64 = 0
+	for k, v := range tree.All() {
+		if k != next || tree.Get(k) != v {
+			t.Fatal("All(): Mismatch:", k, "!=", next)
+		}
+		if next == 500 {
+			break
+		}
+		next++
+	}
+	next = 999
+	for k := range tree.Backward() {
+		if k != next {
+			t.Fatal("Backward(): Mismatch:", k, "!=", next)
+		}
+		next--
+	}
+	if next != math.MaxUint64 {
+		t.Fatal("Backward(): Stopped at", next)
+	}
+
+	// The channel is closed by the goroutine on its way out, so draining it
+	// ends only once the goroutine stopped, well before the last entry
+	for j:=0; j<10; j++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := tree.IterateContext(ctx)
+		<-ch
+		<-ch
+		cancel()
+		rest := 0
+		for range ch {
+			rest++
+		}
+		if rest >= 998 {
+			t.Error("IterateContext(): Sent every entry after cancel")
+		}
 	}
-	return value
 }
 
-func (self *BtreeTest) Delete(key interface{}) {
-	delete(self.reference, key.(uint64))
-	self.tree.Delete(key)
-	
-	verify := self.tree.Get(key) 
-	if (verify != nil) {
-		self.test.Error("Delete(): Value was not deleted:", key)
-		self.test.FailNow()
-	}
-	self.tree.Check(self.test)
-}
-
-func (self *BtreeTest) Iterate() chan Entry {
-	rval := make (chan Entry) 
-	treechan := self.tree.Iterate()
-	if treechan == nil {
-		return nil
-	}
-	checker := func() {
-		var lastkey uint64
-		var checklast bool = false
-		for entry := range treechan {
-			if (checklast) {
-				if entry.Key.(uint64) < lastkey {
-					self.test.Error("Iterate(): Values are not increasing:", entry.Key, ">=", lastkey)
+func TestSeq(t *testing.T) {
+	SeqTest(t, NewBPlusTree[uint64, int](8))
+	SeqTest(t, NewBTree[uint64, int](8))
+}
+
+func TestRank(t *testing.T) {
+	src := rand.NewSource(time.Now().UnixNano())
+	for _,order := range [] int {4, 8, 32} {
+		tree := NewBPlusTree[uint64, int](order)
+		keys := make ([] uint64, 0, 1000)
+		for j:=0; j<2000; j++ {
+			key := uint64(src.Int63() % 2000) * 2
+			index, found := slices.BinarySearch(keys, key)
+			if j % 3 == 2 {
+				if found {
+					keys = slices.Delete(keys, index, index+1)
+				}
+				tree.Delete(key)
+			}else{
+				if ! found {
+					keys = slices.Insert(keys, index, key)
 				}
+				tree.Put(key, j)
 			}
-			checklast = true
-			lastkey = entry.Key.(uint64)
-			
-			refval, ok := self.reference[entry.Key.(uint64)]
-			if (!ok) {
-				self.test.Error("Iterate(): Iteration produced a false key:", entry.Key)
+		}
+		
+		for j:=0; j<200; j++ {
+			key := uint64(src.Int63() % 4002)
+			index, _ := slices.BinarySearch(keys, key)
+			if tree.Rank(key) != index {
+				t.Fatal("Rank(): Mismatch:", tree.Rank(key), "!=", index)
 			}
 			
-			if refval != entry.Value.(int) {
-				self.test.Error("Iterate(): Iteration discovered a false value:", entry.Value)
+			k, v, ok := tree.Select(index)
+			if ok != (index < len(keys)) || (ok && (k != keys[index] || v != tree.Get(k))) {
+				t.Fatal("Select(): Mismatch at", index, k, ok)
 			}
-			
-			rval <- entry
+
+			hi := key + uint64(src.Int63() % 500)
+			last, _ := slices.BinarySearch(keys, hi)
+			if found := tree.CountRange(Inclusive(key), Exclusive(hi)); found != last - index {
+				t.Fatal("CountRange(): Mismatch:", found, "!=", last - index)
+			}
+		}
+		if _, _, ok := tree.Select(-1); ok {
+			t.Fatal("Select(): Negative index found an entry")
+		}
+		if tree.CountRange(Unbounded[uint64](), Unbounded[uint64]()) != len(keys) {
+			t.Fatal("CountRange(): Wrong size", len(keys))
 		}
-		close(rval)
 	}
-	go checker()
-	return rval
 }
 
-func RandomTest(t *testing.T, tree Treelike, seed int64, iterations int, insertions int) {
-	deletions := 5 * insertions
-	t.Log("Random seed:", seed)
+func NilTest(t *testing.T, tree Treelike[string, *int]) {
+	one := 1
+	tree.Put("nil", nil)
+	tree.Put("one", &one)
+	
+	if value, ok := tree.GetOK("nil"); !ok || value != nil {
+		t.Error("GetOK(): Stored nil not found:", value, ok)
+	}
+	if value, ok := tree.GetOK("one"); !ok || value != &one {
+		t.Error("GetOK(): Mismatch:", value, ok)
+	}
+	if value, ok := tree.GetOK("two"); ok || value != nil {
+		t.Error("GetOK(): Missing key found:", value, ok)
+	}
+	if ! tree.Has("nil") || tree.Has("two") {
+		t.Error("Has(): Mismatch")
+	}
+	tree.Delete("nil")
+	if tree.Has("nil") {
+		t.Error("Delete(): Value was not deleted: nil")
+	}
+}
 
-	src := rand.NewSource(seed)
-	test := new (BtreeTest)
-	test.test = t
-	test.reference = make (map[uint64] int)
-	test.tree = tree
+func TestNilValues(t *testing.T) {
+	NilTest(t, NewBPlusTree[string, *int](4))
+	NilTest(t, NewBTree[string, *int](4))
+}
 
-	keys := make([] uint64, insertions*iterations, insertions*iterations);
+func TestUpsert(t *testing.T) {
+	tree := NewBPlusTree[string, int](4)
+	words := strings.Fields("the quick brown fox jumps over the lazy dog the end")
+	for _,w := range words {
+		tree.Upsert(w, func(old int, exists bool) int {
+			return old + 1
+		})
+	}
+	if tree.Get("the") != 3 || tree.Get("fox") != 1 || tree.Len() != 9 {
+		t.Error("Upsert(): Wrong counts:", tree.Get("the"), tree.Get("fox"), tree.Len())
+	}
 
-	for i:=0; i<iterations; i++ {
+	if value, ok := tree.Update("the", func(old int) int { return old * 10 }); !ok || value != 30 {
+		t.Error("Update(): Mismatch:", value, ok)
+	}
+	if value, ok := tree.Update("cat", func(old int) int { return 1 }); ok || value != 0 || tree.Has("cat") {
+		t.Error("Update(): Inserted a missing key:", value, ok)
+	}
+	
+	if value, ok := tree.PutIfAbsent("fox", 5); ok || value != 1 {
+		t.Error("PutIfAbsent(): Replaced a present key:", value, ok)
+	}
+	if value, ok := tree.PutIfAbsent("cat", 5); !ok || value != 5 || tree.Get("cat") != 5 {
+		t.Error("PutIfAbsent(): Mismatch:", value, ok)
+	}
 
-		for j:=0; j<insertions; j++ {
-			key := uint64(src.Int63())
-			keys[(insertions*i)+j] = key			
-			test.Put(key, j)
-			// Double insert every so often...
-			if (j % 100) == 10 {
-				test.Put(key, j+1)
-			}
+	if CompareAndSwap(tree, "cat", 4, 6) || tree.Get("cat") != 5 {
+		t.Error("CompareAndSwap(): Swapped a different value")
+	}
+	if ! CompareAndSwap(tree, "cat", 5, 6) || tree.Get("cat") != 6 {
+		t.Error("CompareAndSwap(): Mismatch:", tree.Get("cat"))
+	}
+	if CompareAndSwap(tree, "cow", 0, 1) || tree.Has("cow") {
+		t.Error("CompareAndSwap(): Inserted a missing key")
+	}
+}
+
+type Nearestlike interface {
+	Treelike[uint64, int]
+	Min() (uint64, int, bool)
+	Max() (uint64, int, bool)
+	Floor(uint64) (uint64, int, bool)
+	Ceiling(uint64) (uint64, int, bool)
+	Lower(uint64) (uint64, int, bool)
+	Higher(uint64) (uint64, int, bool)
+}
+
+func NearestTest(t *testing.T, tree Nearestlike, seed int64) {
+	check := func(name string, k uint64, v int, ok bool, index int, keys [] uint64) {
+		if ok != (index >= 0 && index < len(keys)) || (ok && (k != keys[index] || v != int(k))) {
+			t.Fatal(name + "(): Mismatch:", k, v, ok, index)
+		}
+	}
+	src := rand.NewSource(seed)
+	keys := make ([] uint64, 0, 300)
+	k, v, ok := tree.Min()
+	check("Min", k, v, ok, 0, keys)
+
+	for j:=0; j<300; j++ {
+		key := uint64(src.Int63() % 1000) * 2
+		if index, found := slices.BinarySearch(keys, key); !found {
+			keys = slices.Insert(keys, index, key)
+		}
+		tree.Put(key, int(key))
+	}
+	k, v, ok = tree.Min()
+	check("Min", k, v, ok, 0, keys)
+	k, v, ok = tree.Max()
+	check("Max", k, v, ok, len(keys)-1, keys)
+
+	for j:=0; j<300; j++ {
+		key := uint64(src.Int63() % 2002)
+		index, found := slices.BinarySearch(keys, key)
+		below, above := index - 1, index
+		if found {
+			above++
 		}
+		k, v, ok = tree.Lower(key)
+		check("Lower", k, v, ok, below, keys)
+		k, v, ok = tree.Higher(key)
+		check("Higher", k, v, ok, above, keys)
+		if found {
+			below, above = index, index
+		}
+		k, v, ok = tree.Floor(key)
+		check("Floor", k, v, ok, below, keys)
+		k, v, ok = tree.Ceiling(key)
+		check("Ceiling", k, v, ok, above, keys)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	seed := time.Now().UnixNano()
+	t.Log("Random seed:", seed)
+	for _,order := range [] int {4, 8, 32} {
+		NearestTest(t, NewBPlusTree[uint64, int](order), seed)
+		NearestTest(t, NewBTree[uint64, int](order), seed)
+	}
+}
+
+type Poplike interface {
+	Treelike[uint64, int]
+	PopMin() (uint64, int, bool)
+	PopMax() (uint64, int, bool)
+	DeleteMin(int) [] Entry[uint64, int]
+}
+
+func PopTest(t *testing.T, tree Poplike) {
+	for j:=0; j<1000; j++ {
+		tree.Put(uint64(j * 7 % 1000), j)
+	}
 	
-		for j:=0; j<deletions; j++ {
-			listindex := uint(src.Int63()) % uint(insertions*(i+1))
-			test.Delete(keys[listindex])
+	var lo, hi uint64 = 0, 999
+	for j:=0; j<300; j++ {
+		k, _, ok := tree.PopMin()
+		if ! ok || k != lo {
+			t.Fatal("PopMin(): Mismatch:", k, ok, "!=", lo)
+		}
+		k, _, ok = tree.PopMax()
+		if ! ok || k != hi {
+			t.Fatal("PopMax(): Mismatch:", k, ok, "!=", hi)
 		}
+		lo, hi = lo + 1, hi - 1
 	}
 	
-	ch := test.Iterate()
-	if ch != nil {
-		count := 0
-		for entry := range ch {
-			value := test.reference[entry.Key.(uint64)]
-			if (value != entry.Value.(int)) {
-				t.Error("Iterate(): Iteration discovered a false value:", entry.Value)
-				t.FailNow()
+	removed := tree.DeleteMin(100)
+	for i, e := range removed {
+		if e.Key != lo + uint64(i) {
+			t.Fatal("DeleteMin(): Mismatch:", e.Key, "!=", lo + uint64(i))
+		}
+	}
+	if len(removed) != 100 || tree.Len() != 300 || tree.Has(lo + 99) || ! tree.Has(lo + 100) {
+		t.Fatal("DeleteMin(): Removed the wrong entries")
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("DeleteMin():", err)
+	}
+	if len(tree.DeleteMin(1000)) != 300 || tree.Len() != 0 {
+		t.Fatal("DeleteMin(): Did not empty the tree")
+	}
+	if _, _, ok := tree.PopMin(); ok {
+		t.Fatal("PopMin(): Found an entry in an empty tree")
+	}
+	if _, _, ok := tree.PopMax(); ok {
+		t.Fatal("PopMax(): Found an entry in an empty tree")
+	}
+	tree.Put(5, 5)
+	if k, _, ok := tree.PopMax(); ! ok || k != 5 {
+		t.Fatal("PopMax(): Mismatch after refill:", k, ok)
+	}
+}
+
+func TestPop(t *testing.T) {
+	for _,order := range [] int {4, 8, 32} {
+		PopTest(t, NewBPlusTree[uint64, int](order))
+		PopTest(t, NewBTree[uint64, int](order))
+	}
+}
+
+type Bulklike interface {
+	Seqlike
+	BulkLoad(iter.Seq2[uint64, int], float64) error
+}
+
+func BulkTest(t *testing.T, tree Bulklike, count int, fill float64) {
+	seq := func(yield func(uint64, int) bool) {
+		for j:=0; j<count; j++ {
+			if ! yield(uint64(j * 3), j) {
+				return
 			}
-			count += 1
 		}
-		if count == 0 {
-			test.test.Error("Iteration produced no items!")
+	}
+	if err := tree.BulkLoad(seq, fill); err != nil {
+		t.Fatal("BulkLoad():", err)
+	}
+	if tree.Len() != count {
+		t.Fatal("BulkLoad(): Len() mismatch:", tree.Len(), "!=", count)
+	}
+	j := 0
+	for k, v := range tree.All() {
+		if k != uint64(j * 3) || v != j {
+			t.Fatal("BulkLoad(): Mismatch:", k, v, "!=", j * 3, j)
+		}
+		j++
+	}
+	if j != count {
+		t.Fatal("BulkLoad(): Iterated", j, "of", count, "entries")
+	}
+	if count > 0 && tree.BulkLoad(seq, fill) != ErrNotEmpty {
+		t.Fatal("BulkLoad(): Loaded into a full tree")
+	}
+
+	// The loaded tree must keep working
+	for j:=0; j<count; j+=2 {
+		tree.Delete(uint64(j * 3))
+		tree.Put(uint64(j * 3 + 1), j)
+	}
+	for j:=0; j<count; j++ {
+		value, ok := tree.GetOK(uint64(j * 3 + (j + 1) % 2))
+		if ! ok || value != j {
+			t.Fatal("BulkLoad(): Lost", j * 3 + (j + 1) % 2, "after changes")
 		}
 	}
 }
 
-func xTestAutoRandomBTree(t *testing.T) {
-	order := 4
-	iterations := 2
-	insertions := 1000
-	tree := NewBTree(order)
-	seed := time.Now().UnixNano()
-	RandomTest(t, tree, seed, iterations, insertions)
+func TestBulkLoad(t *testing.T) {
+	for _,order := range [] int {4, 8, 32} {
+		for _,fill := range [] float64 {0.5, 0.75, 1} {
+			for _,count := range [] int {0, 1, order, order * 3 + 1, 10000} {
+				BulkTest(t, NewBPlusTree[uint64, int](order), count, fill)
+				BulkTest(t, NewBTree[uint64, int](order), count, fill)
+			}
+		}
+	}
+
+	unsorted := func(yield func(uint64, int) bool) {
+		for _,k := range [] uint64 {1, 2, 3, 5, 4} {
+			if ! yield(k, 0) {
+				return
+			}
+		}
+	}
+	bplus := NewBPlusTree[uint64, int](4)
+	if bplus.BulkLoad(unsorted, 1) != ErrNotSorted || bplus.Len() != 0 {
+		t.Error("BulkLoad(): Accepted unsorted keys")
+	}
+	btree := NewBTree[uint64, int](4)
+	if btree.BulkLoad(unsorted, 1) != ErrNotSorted || btree.Len() != 0 {
+		t.Error("BulkLoad(): Accepted unsorted keys")
+	}
 }
 
-func xTestRandom(t *testing.T) {
-	orders := [] int {4, 8, 16, 32, 64, 128}
-	iterations := 10
-	insertions := 1000
-	
-	var tree * BTree;
-	for _,order := range orders {
-		tree = NewBTree(order)
-		runtime.GC()
+func TestApplyBatch(t *testing.T) {
+	for _,order := range [] int {2, 4, 8, 32} {
+		seed := time.Now().UnixNano()
+		src := rand.NewSource(seed)
+		tree := NewBPlusTree[uint64, int](order)
+		values := map[uint64] int {}
+		for round:=0; round<100; round++ {
+			// Some rounds grow the tree, others empty most of it
+			batch := new (Batch[uint64, int])
+			for j:=0; j<int(src.Int63() % 2000); j++ {
+				key := uint64(src.Int63() % 5000)
+				if round % 10 > 6 || src.Int63() % 3 == 0 {
+					batch.Delete(key)
+					delete(values, key)
+				}else{
+					batch.Put(key, j)
+					values[key] = j
+				}
+			}
+			tree.ApplyBatch(batch)
+			if tree.Len() != len(values) {
+				t.Fatal("ApplyBatch(): Len() mismatch:", tree.Len(), "!=", len(values), "seed:", seed)
+			}
+			for key, value := range values {
+				if got, ok := tree.GetOK(key); ! ok || got != value {
+					t.Fatal("ApplyBatch(): Mismatch at", key, got, "!=", value, "seed:", seed)
+				}
+			}
+			count := 0
+			var last uint64
+			for key := range tree.All() {
+				if count > 0 && key <= last {
+					t.Fatal("ApplyBatch(): Keys out of order", last, key, "seed:", seed)
+				}
+				last = key
+				count++
+			}
+			for range tree.Backward() {
+				count--
+			}
+			if count != 0 {
+				t.Fatal("ApplyBatch(): Leaf chain is broken, seed:", seed)
+			}
+			if err := tree.Validate(); err != nil {
+				t.Fatal("ApplyBatch():", err, "seed:", seed)
+			}
+		}
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	for _,order := range [] int {2, 4, 8, 32} {
 		seed := time.Now().UnixNano()
-		RandomTest(t, tree, seed, iterations, insertions)
+		src := rand.NewSource(seed)
+		tree := NewBPlusTree[uint64, int](order)
+		values := map[uint64] int {}
+		for round:=0; round<100; round++ {
+			for j:=0; j<int(src.Int63() % 1000); j++ {
+				key := uint64(src.Int63() % 5000)
+				tree.Put(key, j)
+				values[key] = j
+			}
+			first := uint64(src.Int63() % 5000)
+			lo, hi := Inclusive(first), Exclusive(first + uint64(src.Int63() % 2000))
+			switch round % 8 {
+			case 3:
+				lo = Unbounded[uint64]()
+			case 5:
+				hi = Unbounded[uint64]()
+			case 7:
+				lo, hi = Exclusive(first), Inclusive(first)
+			}
+			expect := 0
+			for key := range values {
+				if lo.above(cmp.Compare[uint64], key) && hi.below(cmp.Compare[uint64], key) {
+					delete(values, key)
+					expect++
+				}
+			}
+			if removed := tree.DeleteRange(lo, hi); removed != expect {
+				t.Fatal("DeleteRange(): Removed", removed, "!=", expect, "seed:", seed)
+			}
+			if tree.Len() != len(values) {
+				t.Fatal("DeleteRange(): Len() mismatch:", tree.Len(), "!=", len(values), "seed:", seed)
+			}
+			for key, value := range values {
+				if got, ok := tree.GetOK(key); ! ok || got != value {
+					t.Fatal("DeleteRange(): Mismatch at", key, got, "!=", value, "seed:", seed)
+				}
+			}
+			count := 0
+			for range tree.All() {
+				count++
+			}
+			for range tree.Backward() {
+				count--
+			}
+			if count != 0 {
+				t.Fatal("DeleteRange(): Leaf chain is broken, seed:", seed)
+			}
+			if err := tree.Validate(); err != nil {
+				t.Fatal("DeleteRange():", err, "seed:", seed)
+			}
+		}
 	}
 }
 
-func TestAutoRandomBplus(t *testing.T) {
-	order := 4
-	iterations := 2
-	insertions := 100
-	tree := NewBPlusTree(order)
-	seed := time.Now().UnixNano()
-	RandomTest(t, tree, seed, iterations, insertions)
+// A memFile that counts the pages read from it
+type countFile struct {
+	*memFile
+	reads int
 }
 
-func TestRandomBplus(t *testing.T) {
-	orders := [] int {4, 8, 16, 32, 64, 128}
-	iterations := 10
-	insertions := 1000
-	
-	var tree * BPlusTree;
-	for _,order := range orders {
-		tree = NewBPlusTree(order)
-		runtime.GC()
+func (self *countFile) ReadAt(p [] byte, off int64) (int, error) {
+	self.reads++
+	return self.memFile.ReadAt(p, off)
+}
+
+func TestDeleteRangeReads(t *testing.T) {
+	file := &countFile{memFile: new (memFile)}
+	factory, _ := NewPageFactory(file, 256, cmp.Compare[uint64], BinaryCodec[uint64](), BinaryCodec[uint64]())
+	tree := NewBPlusTreeFactory[uint64, uint64](factory.Order(), cmp.Compare[uint64], factory)
+	for j:=uint64(0); j<20000; j++ {
+		tree.Put(j, j)
+	}
+	leaves := 0
+	for n := tree.head; n != nil; n = n.Next() {
+		leaves++
+	}
+	factory.Pool().SetCapacity(4 * 256)
+
+	// Only the internal nodes and the leaves at the ends are read
+	file.reads = 0
+	if count := tree.DeleteRange(Inclusive[uint64](1000), Exclusive[uint64](19000)); count != 18000 {
+		t.Fatal("DeleteRange(): Removed", count, "keys")
+	}
+	if file.reads > leaves / 4 {
+		t.Error("DeleteRange(): Read", file.reads, "pages to remove most of", leaves, "leaves")
+	}
+	if err := tree.Validate(); err != nil || factory.Err() != nil {
+		t.Fatal("Validate():", err, factory.Err())
+	}
+}
+
+func TestSplitJoin(t *testing.T) {
+	for _,order := range [] int {2, 4, 8, 32} {
 		seed := time.Now().UnixNano()
-		RandomTest(t, tree, seed, iterations, insertions)
+		src := rand.NewSource(seed)
+		tree := NewBPlusTree[uint64, int](order)
+		values := map[uint64] int {}
+		for round:=0; round<50; round++ {
+			for j:=0; j<int(src.Int63() % 1000); j++ {
+				key := uint64(src.Int63() % 5000)
+				tree.Put(key, j)
+				values[key] = j
+			}
+			at := uint64(src.Int63() % 5200)
+			left, right := tree.SplitAt(at)
+			if tree.Len() != 0 || left.Len() + right.Len() != len(values) {
+				t.Fatal("SplitAt(): Len() mismatch:", left.Len(), right.Len(), "seed:", seed)
+			}
+			for key, value := range values {
+				half := left
+				if key >= at {
+					half = right
+				}
+				if got, ok := half.GetOK(key); ! ok || got != value {
+					t.Fatal("SplitAt(): Mismatch at", key, got, "!=", value, "seed:", seed)
+				}
+			}
+			for _, half := range [] *BPlusTree[uint64, int] {left, right} {
+				if err := half.Validate(); err != nil {
+					t.Fatal("SplitAt():", err, "seed:", seed)
+				}
+			}
+			if left.Len() > 0 && right.Len() > 0 {
+				if _, err := Join(right, left); err != ErrOverlap {
+					t.Fatal("Join(): Joined overlapping trees")
+				}
+			}
+			var err error
+			tree, err = Join(left, right)
+			if err != nil {
+				t.Fatal("Join():", err)
+			}
+			if left.Len() != 0 || right.Len() != 0 || tree.Len() != len(values) {
+				t.Fatal("Join(): Len() mismatch:", tree.Len(), "!=", len(values), "seed:", seed)
+			}
+			j := 0
+			var last uint64
+			for key, value := range tree.All() {
+				if (j > 0 && key <= last) || values[key] != value {
+					t.Fatal("Join(): Bad entry", key, value, "seed:", seed)
+				}
+				last = key
+				j++
+			}
+			for range tree.Backward() {
+				j--
+			}
+			if j != 0 {
+				t.Fatal("Join(): Leaf chain is broken, seed:", seed)
+			}
+			if err := tree.Validate(); err != nil {
+				t.Fatal("Join():", err, "seed:", seed)
+			}
+		}
+	}
+
+	// Trees built separately in one factory
+	shared := NewSimpleFactory[uint64, int](cmp.Compare[uint64])
+	small := NewBPlusTreeFactory[uint64, int](4, cmp.Compare[uint64], shared)
+	large := NewBPlusTreeFactory[uint64, int](4, cmp.Compare[uint64], shared)
+	for j:=0; j<1000; j++ {
+		if j < 10 {
+			small.Put(uint64(j), j)
+		}
+		large.Put(uint64(j + 100), j + 100)
+	}
+	tree, err := Join(small, large)
+	if err != nil || tree.Len() != 1010 || tree.Get(5) != 5 || tree.Get(1099) != 1099 {
+		t.Fatal("Join(): Failed to join separate trees", err)
+	}
+	if _, err := Join(tree, NewBPlusTree[uint64, int](8)); err != ErrOrder {
+		t.Fatal("Join(): Joined trees of different orders")
+	}
+
+	// Trees of different factories may be ordered differently
+	reverse := NewBPlusTreeFunc[uint64, int](4, func(a, b uint64) int {
+		return cmp.Compare(b, a)
+	})
+	reverse.Put(2000, 0)
+	reverse.Put(1500, 0)
+	if _, err := Join(tree, reverse); err != ErrFactory {
+		t.Fatal("Join(): Joined trees with different orderings", err)
+	}
+
+	// Nodes can't move between files
+	codec := BinaryCodec[uint64]()
+	one, _ := NewPageFactory(new (memFile), 256, cmp.Compare[uint64], codec, codec)
+	two, _ := NewPageFactory(new (memFile), 256, cmp.Compare[uint64], codec, codec)
+	low := NewBPlusTreeFactory[uint64, uint64](one.Order(), cmp.Compare[uint64], one)
+	high := NewBPlusTreeFactory[uint64, uint64](one.Order(), cmp.Compare[uint64], two)
+	low.Put(1, 1)
+	high.Put(2, 2)
+	if _, err := Join(low, high); err != ErrFactory {
+		t.Fatal("Join(): Joined trees in different files", err)
+	}
+	if low.Get(1) != 1 || high.Get(2) != 2 {
+		t.Fatal("Join(): Changed the trees it did not join")
 	}
 }
 
-func TestRandomCznic(t *testing.T) {
-	iterations := 10
-	insertions := 1000
-	
-	tree := CznicTree()
+func TestSplitJoinPages(t *testing.T) {
+	codec := BinaryCodec[uint64]()
+	factory, _ := NewPageFactory(new (memFile), 256, cmp.Compare[uint64], codec, codec)
+	tree := NewBPlusTreeFactory[uint64, uint64](factory.Order(), cmp.Compare[uint64], factory)
+	for j:=uint64(0); j<2000; j++ {
+		tree.Put(j, j)
+	}
+	var nodes func(n Node[uint64, uint64]) int
+	nodes = func(n Node[uint64, uint64]) int {
+		count := 1
+		for _, child := range tree.load(n).Nodes {
+			count += nodes(child)
+		}
+		return count
+	}
+
+	// Every page but the header is in the tree or on the free list
+	src := rand.NewSource(1)
+	for round:=0; round<100; round++ {
+		left, right := tree.SplitAt(uint64(src.Int63() % 2200))
+		tree, _ = Join(left, right)
+		free := 0
+		for page := factory.free; page != 0; free++ {
+			buf := factory.pin(page)
+			next := binary.LittleEndian.Uint32(buf[4:])
+			factory.unpin(page, false)
+			page = next
+		}
+		if used := nodes(tree.root); int(factory.pages) - 1 != used + free {
+			t.Fatal("SplitAt(), Join(): Leaked", int(factory.pages) - 1 - used - free, "of", factory.pages, "pages in round", round)
+		}
+	}
+	if err := tree.Validate(); err != nil || tree.Len() != 2000 || factory.Err() != nil {
+		t.Fatal("SplitAt(), Join():", err, tree.Len(), factory.Err())
+	}
+
+	// An emptied tree gets a page again when it is written to
+	left, right := tree.SplitAt(1000)
+	tree.Put(1, 1)
+	if err := tree.Validate(); err != nil || tree.Get(1) != 1 || left.Len() + right.Len() != 2000 {
+		t.Fatal("SplitAt(): Emptied tree is unusable", err)
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
 	seed := time.Now().UnixNano()
-	RandomTest(t, tree, seed, iterations, insertions)
+	src := rand.NewSource(seed)
+	for _,size := range [] int {0, 10, 1000} {
+		a, b := NewBPlusTree[uint64, int](8), NewBPlusTree[uint64, int](8)
+		mine, theirs := map[uint64] int {}, map[uint64] int {}
+		for j:=0; j<size; j++ {
+			key := uint64(src.Int63() % int64(size * 2))
+			a.Put(key, j)
+			mine[key] = j
+			key = uint64(src.Int63() % int64(size * 2))
+			b.Put(key, -j)
+			theirs[key] = -j
+		}
+		sum := func(key uint64, x, y int) int {
+			return x + y
+		}
+		verify := func(name string, tree *BPlusTree[uint64, int], keep func(bool, bool) bool) {
+			expect := map[uint64] int {}
+			for key, value := range mine {
+				if other, ok := theirs[key]; ok {
+					if keep(true, true) {
+						expect[key] = value + other
+					}
+				}else if keep(true, false) {
+					expect[key] = value
+				}
+			}
+			for key, value := range theirs {
+				if _, ok := mine[key]; ! ok && keep(false, true) {
+					expect[key] = value
+				}
+			}
+			if tree.Len() != len(expect) {
+				t.Fatal(name, "Len() mismatch:", tree.Len(), "!=", len(expect), "seed:", seed)
+			}
+			for key, value := range tree.All() {
+				if other, ok := expect[key]; ! ok || other != value {
+					t.Fatal(name, "Mismatch at", key, value, "!=", other, "seed:", seed)
+				}
+			}
+		}
+		check := func(name string, keep func(bool, bool) bool) func(*BPlusTree[uint64, int], error) {
+			return func(tree *BPlusTree[uint64, int], err error) {
+				if err != nil {
+					t.Fatal(name, err)
+				}
+				verify(name, tree, keep)
+			}
+		}
+		check("Union():", func(x, y bool) bool { return true })(a.Union(b, sum))
+		check("Intersect():", func(x, y bool) bool { return x && y })(a.Intersect(b, sum))
+		check("Difference():", func(x, y bool) bool { return x && ! y })(a.Difference(b))
+		check("SymmetricDifference():", func(x, y bool) bool { return x != y })(a.SymmetricDifference(b))
+		if a.Len() != len(mine) || b.Len() != len(theirs) {
+			t.Fatal("Set operations changed their inputs")
+		}
+	}
+
+	// Trees ordered the other way
+	a, b := NewBPlusTree[uint64, int](8), NewBPlusTreeFunc[uint64, int](8, func(x, y uint64) int {
+		return cmp.Compare(y, x)
+	})
+	for j:=0; j<10; j++ {
+		a.Put(uint64(j), j)
+		b.Put(uint64(j + 5), j)
+	}
+	if tree, err := a.Union(b, nil); err == nil {
+		t.Error("Union(): Combined trees with different orders into", tree.Len(), "keys")
+	}
 }
 
-func SetupBenchmark(b *testing.B, tree Treelike) (int64, rand.Source) {
-	prefill := 3000000
+func TestDiff(t *testing.T) {
 	seed := time.Now().UnixNano()
 	src := rand.NewSource(seed)
+	for _,order := range [] int {2, 4, 32} {
+		a, b := NewBPlusTree[uint64, int](order), NewBPlusTree[uint64, int](order)
+		for j:=0; j<3000; j++ {
+			key := uint64(src.Int63() % 4000)
+			a.Put(key, j)
+			switch src.Int63() % 4 {
+			case 0:
+			case 1:
+				b.Put(key, -j)
+			default:
+				b.Put(key, j)
+			}
+			if src.Int63() % 8 == 0 {
+				b.Put(uint64(src.Int63() % 4000 + 4000), j)
+			}
+		}
+		expect := map[uint64] int {}
+		for key, value := range a.All() {
+			if other, ok := b.GetOK(key); ! ok {
+				expect[key] = Removed
+			}else if other != value {
+				expect[key] = Changed
+			}
+		}
+		for key := range b.All() {
+			if ! a.Has(key) {
+				expect[key] = Added
+			}
+		}
+		count := 0
+		var last uint64
+		for change := range Diff(a, b) {
+			if count > 0 && change.Key <= last {
+				t.Fatal("Diff(): Keys out of order", last, change.Key, "seed:", seed)
+			}
+			if expect[change.Key] != change.Kind || a.Get(change.Key) != change.Old || b.Get(change.Key) != change.New {
+				t.Fatal("Diff(): Bad change", change, "seed:", seed)
+			}
+			last = change.Key
+			count++
+		}
+		if count != len(expect) {
+			t.Fatal("Diff(): Found", count, "of", len(expect), "changes, seed:", seed)
+		}
 
-	/*
-	if prefill < b.N {
-		prefill = b.N
+		// A copy shares every node
+		same := *a
+		for change := range Diff(a, &same) {
+			t.Fatal("Diff(): Change between identical trees", change)
+		}
 	}
-	*/
-	
-	for j:=0; j<prefill; j++ {
-		tree.Put(uint64(src.Int63()), j)
-	}	
+}
+
+func TestDiffShared(t *testing.T) {
+	file := &countFile{memFile: new (memFile)}
+	factory, _ := NewPageFactory(file, 256, cmp.Compare[uint64], BinaryCodec[uint64](), BinaryCodec[uint64]())
+	a := NewBPlusTreeFactory[uint64, uint64](factory.Order(), cmp.Compare[uint64], factory)
+	for j:=uint64(0); j<20000; j++ {
+		a.Put(j, j)
+	}
+
+	// b copies the path to the first leaf and shares every other node
+	b := &BPlusTree[uint64, uint64]{N: a.N, factory: factory, compare: a.compare, size: a.size}
+	var path [] *MemNode[uint64, uint64]
+	for n := a.root; n != nil; {
+		m := a.load(n)
+		if n.isLeaf() {
+			m.ref = factory.NewLeaf(a.N)
+			m.Entries[0].Value = 1000
+			n = nil
+		}else{
+			m.ref = factory.NewNode(a.N)
+			n = m.Nodes[0]
+		}
+		if len(path) == 0 {
+			b.root = m.ref
+		}else{
+			path[len(path)-1].Nodes[0] = m.ref
+		}
+		path = append(path, m)
+	}
+	// Children first, a node takes its level from its first child
+	for i := len(path) - 1; i >= 0; i-- {
+		a.store(path[i])
+	}
+	b.ends()
+	factory.Pool().SetCapacity(16 * 256)
 
-	return seed, src
+	file.reads = 0
+	var changes [] Change[uint64, uint64]
+	for change := range Diff(a, b) {
+		changes = append(changes, change)
+	}
+	if len(changes) != 1 || changes[0] != (Change[uint64, uint64]{Changed, 0, 0, 1000}) {
+		t.Fatal("Diff(): Found", changes, "instead of the changed first key")
+	}
+	if levels := a.height() + 1; file.reads > 2 * levels {
+		t.Error("Diff(): Read", file.reads, "pages, the trees differ on", levels, "levels")
+	}
 }
 
-func BenchmarkRandomPut(b *testing.B) {
-	order := 128
-	tree := NewBPlusTree(order)
-	_, src := SetupBenchmark(b, tree)
+func TestDiffSnapshot(t *testing.T) {
+	codec := BinaryCodec[uint64]()
+	file := &countFile{memFile: new (memFile)}
+	factory, _ := NewAppendFactory(file, cmp.Compare[uint64], codec, codec)
+	tree := OpenBPlusTree[uint64, uint64](8, cmp.Compare[uint64], factory)
+	for j:=uint64(0); j<20000; j++ {
+		tree.Put(j, j)
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatal("Commit():", err)
+	}
+	tree.Put(5000, 1000)
+	if err := tree.Commit(); err != nil {
+		t.Fatal("Commit():", err)
+	}
+	snapshots, _ := factory.Snapshots()
+	view, err := factory.At(snapshots[1])
+	if err != nil {
+		t.Fatal("At():", err)
+	}
+	old := OpenBPlusTree[uint64, uint64](8, cmp.Compare[uint64], view)
 
-    b.ResetTimer()
-	for j:=0; j<b.N; j++ {
-		tree.Put(uint64(src.Int63()), j)
-	}	
+	// Each tree reads the path to the changed leaf a few times, none of the
+	// thousands of nodes the snapshots share
+	file.reads = 0
+	var changes [] Change[uint64, uint64]
+	for change := range Diff(old, tree) {
+		changes = append(changes, change)
+	}
+	if len(changes) != 1 || changes[0] != (Change[uint64, uint64]{Changed, 5000, 5000, 1000}) {
+		t.Fatal("Diff(): Found", changes, "instead of the changed key")
+	}
+	if levels := tree.height() + 1; file.reads > 2 * 5 * levels {
+		t.Error("Diff(): Read", file.reads, "times, the snapshots differ on", levels, "levels")
+	}
 }
 
-func BenchmarkRandomGet(b *testing.B) {
-	order := 128
-	tree := NewBPlusTree(order)
-	seed, src := SetupBenchmark(b, tree)
+func TestValidate(t *testing.T) {
+	bplus := NewBPlusTree[uint64, int](4)
+	btree := NewBTree[uint64, int](4)
+	for j:=0; j<1000; j++ {
+		bplus.Put(uint64(j), j)
+		btree.Put(uint64(j), j)
+	}
+	if err := bplus.Validate(); err != nil {
+		t.Fatal("Validate():", err)
+	}
+	if err := btree.Validate(); err != nil {
+		t.Fatal("Validate():", err)
+	}
+
+	// Break the order of a leaf
+	leaf := bplus.load(bplus.head)
+	leaf.Entries[0], leaf.Entries[1] = leaf.Entries[1], leaf.Entries[0]
+	bplus.store(leaf)
+	if bplus.Validate() == nil {
+		t.Error("Validate(): Missed keys out of order")
+	}
+	leaf.Entries[0], leaf.Entries[1] = leaf.Entries[1], leaf.Entries[0]
+	bplus.store(leaf)
 
-    b.ResetTimer()
-	src = rand.NewSource(seed)
-	for j:=0; j<b.N; j++ {
-		tree.Get(uint64(src.Int63()))
+	// Break the leaf chain
+	leaf.neighbor = nil
+	bplus.store(leaf)
+	if bplus.Validate() == nil {
+		t.Error("Validate(): Missed a broken leaf chain")
+	}
+
+	btree.Stats.Leaves++
+	if btree.Validate() == nil {
+		t.Error("Validate(): Missed a wrong leaf count")
 	}
 }
 
-func BenchmarkRandomDelete(b *testing.B) {
-	order := 128
-	tree := NewBPlusTree(order)
-	seed, src := SetupBenchmark(b, tree)
+// A PageFile in memory
+type memFile struct {
+	data [] byte
+}
 
-    b.ResetTimer()
-	src = rand.NewSource(seed)
-	for j:=0; j<b.N; j++ {
-		tree.Delete(uint64(src.Int63()))
+func (self *memFile) ReadAt(p [] byte, off int64) (int, error) {
+	if off + int64(len(p)) > int64(len(self.data)) {
+		return 0, io.EOF
 	}
+	return copy(p, self.data[off:]), nil
 }
 
-/*
-func BenchmarkIteration(b *testing.B) {
-	order := 128
-	tree := NewBPlusTree(order)
-	SetupBenchmark(b, tree)
+func (self *memFile) size() int64 {
+	return int64(len(self.data))
+}
 
-    b.ResetTimer()
-	ch := tree.Iterate()
-	for _ = range (ch) {
+func (self *memFile) WriteAt(p [] byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(self.data)) {
+		self.data = append(self.data, make ([] byte, end - int64(len(self.data)))...)
 	}
+	return copy(self.data[off:], p), nil
 }
-*/
 
-func BenchmarkCznicRandomPut(b *testing.B) {
-	tree := CznicTree()
-	_, src := SetupBenchmark(b, tree)
+func TestPageFactory(t *testing.T) {
+	file := new (memFile)
+	factory, err := NewPageFactory(file, 256, cmp.Compare[uint64], BinaryCodec[uint64](), BinaryCodec[int64]())
+	if err != nil {
+		t.Fatal("NewPageFactory():", err)
+	}
+	tree := NewBPlusTreeFactory[uint64, int64](factory.Order(), cmp.Compare[uint64], factory)
+
+	seed := time.Now().UnixNano()
+	src := rand.NewSource(seed)
+	values := map[uint64] int64 {}
+	for j:=0; j<5000; j++ {
+		key := uint64(src.Int63() % 2000)
+		if j % 3 == 2 {
+			tree.Delete(key)
+			delete(values, key)
+		}else{
+			tree.Put(key, int64(j))
+			values[key] = int64(j)
+		}
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Validate():", err, "seed:", seed)
+	}
+	if tree.Len() != len(values) {
+		t.Fatal("PageFactory: Len() mismatch:", tree.Len(), "!=", len(values), "seed:", seed)
+	}
+	for key, value := range values {
+		if got, ok := tree.GetOK(key); ! ok || got != value {
+			t.Fatal("PageFactory: Mismatch at", key, got, "!=", value, "seed:", seed)
+		}
+	}
+
+	// Released pages are used again
+	tree.DeleteRange(Unbounded[uint64](), Unbounded[uint64]())
+	pages, freed := factory.pages, factory.free
+	leaf := factory.NewLeaf(factory.Order())
+	if pageOf(leaf) != freed || factory.pages != pages {
+		t.Error("PageFactory: Allocated page", pageOf(leaf), "instead of released page", freed)
+	}
+	factory.Release(leaf)
+	for key, value := range values {
+		tree.Put(key, value)
+	}
+	factory.Flush()
+	if factory.Err() != nil {
+		t.Error("PageFactory:", factory.Err())
+	}
 
-    b.ResetTimer()
-	for j:=0; j<b.N; j++ {
-		tree.Put(uint64(src.Int63()), j)
-	}	
+	reopened, err := OpenPageFactory(file, cmp.Compare[uint64], BinaryCodec[uint64](), BinaryCodec[int64]())
+	if err != nil || reopened.Order() != factory.Order() {
+		t.Error("OpenPageFactory(): Failed to read the header", err)
+	}
+	if _, err := OpenPageFactory(new (memFile), cmp.Compare[uint64], BinaryCodec[uint64](), BinaryCodec[int64]()); err == nil {
+		t.Error("OpenPageFactory(): Opened an empty file")
+	}
+	if _, err := NewPageFactory(new (memFile), 32, cmp.Compare[uint64], BinaryCodec[uint64](), BinaryCodec[int64]()); err != ErrPageSize {
+		t.Error("NewPageFactory(): Accepted a page that holds one key")
+	}
+	if _, err := NewPageFactory(new (memFile), 256, cmp.Compare[uint64], BinaryCodec[uint64](), BinaryCodec[int]()); err != ErrCodec {
+		t.Error("NewPageFactory(): Accepted a value without a fixed size")
+	}
 }
 
-func BenchmarkCznicRandomGet(b *testing.B) {
-	tree := CznicTree()
-	seed, src := SetupBenchmark(b, tree)
+func TestPageDescent(t *testing.T) {
+	codec := BinaryCodec[uint64]()
+	factory, _ := NewPageFactory(new (memFile), 256, cmp.Compare[uint64], codec, codec)
+	tree := NewBPlusTreeFactory[uint64, uint64](factory.Order(), cmp.Compare[uint64], factory)
+	for j:=uint64(0); j<5000; j++ {
+		tree.Put(j, j)
+	}
 
-    b.ResetTimer()
-	src = rand.NewSource(seed)
-	for j:=0; j<b.N; j++ {
-		tree.Get(uint64(src.Int63()))
+	// Each level of a lookup pins and decodes its page once
+	stats := factory.Pool().Stats
+	if tree.Get(2500) != 2500 {
+		t.Fatal("PageFactory: Lost key 2500")
+	}
+	pins := factory.Pool().Stats.Hits + factory.Pool().Stats.Misses - stats.Hits - stats.Misses
+	if pins != tree.height() + 1 {
+		t.Error("PageFactory: Pinned", pins, "pages to read", tree.height() + 1, "levels")
 	}
 }
 
-func BenchmarkCznicRandomDelete(b *testing.B) {
-	tree := CznicTree()
-	seed, src := SetupBenchmark(b, tree)
+func TestBufferPool(t *testing.T) {
+	file := new (memFile)
+	factory, err := NewPageFactory(file, 128, cmp.Compare[uint64], BinaryCodec[uint64](), BinaryCodec[int64]())
+	if err != nil {
+		t.Fatal("NewPageFactory():", err)
+	}
+	factory.Pool().SetCapacity(8 * 128)
+	tree := NewBPlusTreeFactory[uint64, int64](factory.Order(), cmp.Compare[uint64], factory)
+	for j:=0; j<2000; j++ {
+		tree.Put(uint64(j * 7919 % 2000), int64(j))
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal("Validate():", err)
+	}
+	if stats := factory.Pool().Stats; stats.Hits == 0 || stats.Misses == 0 || stats.Evictions == 0 {
+		t.Error("BufferPool: Counts were not kept:", stats)
+	}
+	if len(factory.Pool().frames) > 8 {
+		t.Error("BufferPool: Holds", len(factory.Pool().frames), "pages, capacity is 8")
+	}
+
+	// Every change reaches the file after a flush
+	if err := factory.Flush(); err != nil {
+		t.Fatal("Flush():", err)
+	}
+	reread, _ := OpenPageFactory(file, cmp.Compare[uint64], BinaryCodec[uint64](), BinaryCodec[int64]())
+	reread.Pool().SetCapacity(0)
+	leaf := tree.head
+	for page := leaf.(PageNode[uint64, int64]).page; page != 0; {
+		mine, theirs := factory.node(page).(PageNode[uint64, int64]).mem(), reread.node(page).(PageNode[uint64, int64]).mem()
+		if ! slices.Equal(mine.Entries, theirs.Entries) {
+			t.Fatal("BufferPool: Page", page, "differs in the file")
+		}
+		page = pageOf(theirs.neighbor)
+	}
 
-    b.ResetTimer()
-	src = rand.NewSource(seed)
-	for j:=0; j<b.N; j++ {
-		tree.Delete(uint64(src.Int63()))
+	// Pinned pages are never evicted
+	pool := NewBufferPool(file, 128, 2 * 128)
+	first, _ := pool.Pin(1)
+	first[0] = 0xff
+	pool.Pin(2)
+	if _, err := pool.Pin(3); err != ErrPoolFull {
+		t.Error("Pin(): Evicted a pinned page", err)
+	}
+	pool.Unpin(1, true)
+	if _, err := pool.Pin(3); err != nil {
+		t.Error("Pin():", err)
+	}
+	if file.data[128] != 0xff {
+		t.Error("Pin(): Dirty page was not written back when it was evicted")
 	}
 }
 
-/*
-func BenchmarkCznicIteration(b *testing.B) {
-	tree := CznicTree()
-	_, src := SetupBenchmark(b, tree)
+var errCrash = errors.New("crash")
 
-    b.ResetTimer()
-	ch := tree.Iterate()
-	for _ = range (ch) {
+// A file that crashes the program at a write, after the writes in left run
+// out. The crashing write is torn in half. A negative left never crashes.
+type crashFile struct {
+	*memFile
+	left *int
+}
+
+func (self crashFile) WriteAt(p [] byte, off int64) (int, error) {
+	if *self.left == 0 {
+		self.memFile.WriteAt(p[:len(p)/2], off)
+		panic(errCrash)
+	}
+	if *self.left > 0 {
+		*self.left--
 	}
+	return self.memFile.WriteAt(p, off)
+}
+
+func TestWAL(t *testing.T) {
+	const size = 128
+	codec := BinaryCodec[uint64]()
+	for crash := 0; ; crash++ {
+		file, log := new (memFile), new (memFile)
+		left := -1
+		wal, err := NewWAL(crashFile{file, &left}, crashFile{log, &left}, size)
+		if err != nil {
+			t.Fatal("NewWAL():", err)
+		}
+		factory, err := NewPageFactory(wal, size, cmp.Compare[uint64], codec, codec)
+		if err != nil {
+			t.Fatal("NewPageFactory():", err)
+		}
+		factory.Pool().SetCapacity(2 * size)
+		tree := OpenBPlusTree[uint64, uint64](factory.Order(), cmp.Compare[uint64], factory)
+		if err := tree.Commit(); err != nil {
+			t.Fatal("Commit():", err)
+		}
+
+		// Crash at the next write after crash writes, nearly every store
+		// evicts a page from the small pool and writes it to the log
+		left = crash
+		src := rand.NewSource(1)
+		committed, current := map[uint64] uint64 {}, map[uint64] uint64 {}
+		crashed, committing := func() (crashed bool, committing bool) {
+			defer func() {
+				if r := recover(); r != nil {
+					if r != errCrash {
+						panic(r)
+					}
+					crashed = true
+				}
+			}()
+			for round := 0; round < 4; round++ {
+				for j := 0; j < 40; j++ {
+					key := uint64(src.Int63() % 200)
+					if j % 4 == 3 {
+						tree.Delete(key)
+						delete(current, key)
+					}else{
+						tree.Put(key, uint64(j))
+						current[key] = uint64(j)
+					}
+				}
+				committing = true
+				if err := tree.Commit(); err != nil {
+					t.Fatal("Commit():", err)
+				}
+				committing = false
+				committed = maps.Clone(current)
+			}
+			return
+		}()
+
+		// Recover and find the last commit, or the one that crashed when it
+		// got far enough
+		wal, err = OpenWAL(file, log)
+		if err != nil {
+			t.Fatal("OpenWAL():", err, "crash:", crash)
+		}
+		factory, err = OpenPageFactory(wal, cmp.Compare[uint64], codec, codec)
+		if err != nil {
+			t.Fatal("OpenPageFactory():", err, "crash:", crash)
+		}
+		tree = OpenBPlusTree[uint64, uint64](factory.Order(), cmp.Compare[uint64], factory)
+		if err := tree.Validate(); err != nil {
+			t.Fatal("Validate():", err, "crash:", crash)
+		}
+		recovered := maps.Collect(tree.All())
+		if ! maps.Equal(recovered, committed) && ! (committing && maps.Equal(recovered, current)) {
+			t.Fatal("WAL: Recovered", len(recovered), "keys that were never committed, crash:", crash)
+		}
+
+		// The recovered tree takes new commits
+		tree.Put(1000, 1000)
+		if err := tree.Commit(); err != nil {
+			t.Fatal("Commit():", err, "crash:", crash)
+		}
+		if ! crashed {
+			break
+		}
+	}
+}
+
+func TestAppendFactory(t *testing.T) {
+	codec := BinaryCodec[uint64]()
+	for crash := 0; ; crash++ {
+		file := new (memFile)
+		left := -1
+		factory, err := NewAppendFactory(crashFile{file, &left}, cmp.Compare[uint64], codec, codec)
+		if err != nil {
+			t.Fatal("NewAppendFactory():", err)
+		}
+		tree := OpenBPlusTree[uint64, uint64](5, cmp.Compare[uint64], factory)
+
+		// Crash at every write of the commits
+		left = crash
+		src := rand.NewSource(1)
+		commits := map[uint64] map[uint64] uint64 {factory.seq: {}}
+		current := map[uint64] uint64 {}
+		written := slices.Clone(file.data)
+		crashed, committing := func() (crashed bool, committing bool) {
+			defer func() {
+				if r := recover(); r != nil {
+					if r != errCrash {
+						panic(r)
+					}
+					crashed = true
+				}
+			}()
+			for round := 0; round < 4; round++ {
+				for j := 0; j < 60; j++ {
+					key := uint64(src.Int63() % 300)
+					if j % 4 == 3 {
+						tree.Delete(key)
+						delete(current, key)
+					}else{
+						tree.Put(key, uint64(j))
+						current[key] = uint64(j)
+					}
+				}
+				if ! slices.Equal(file.data, written) {
+					t.Fatal("AppendFactory: Wrote to the file before Commit()")
+				}
+				committing = true
+				if err := tree.Commit(); err != nil {
+					t.Fatal("Commit():", err)
+				}
+				committing = false
+				commits[factory.seq] = maps.Clone(current)
+
+				// Only the superblocks are written over
+				if ! slices.Equal(file.data[2 * appendSuper:len(written)], written[2 * appendSuper:]) {
+					t.Fatal("AppendFactory: Commit() wrote over committed nodes")
+				}
+				written = slices.Clone(file.data)
+			}
+			return
+		}()
+
+		factory, err = OpenAppendFactory(file, cmp.Compare[uint64], codec, codec)
+		if err != nil {
+			t.Fatal("OpenAppendFactory():", err, "crash:", crash)
+		}
+		tree = OpenBPlusTree[uint64, uint64](5, cmp.Compare[uint64], factory)
+		if err := tree.Validate(); err != nil {
+			t.Fatal("Validate():", err, "crash:", crash)
+		}
+		recovered := maps.Collect(tree.All())
+		if last, ok := commits[factory.seq]; ! (ok && maps.Equal(recovered, last)) && ! (committing && maps.Equal(recovered, current)) {
+			t.Fatal("AppendFactory: Recovered", len(recovered), "keys that were never committed, crash:", crash)
+		}
+		if crashed {
+			continue
+		}
+
+		// Every commit can still be read
+		snapshots, err := factory.Snapshots()
+		if err != nil || len(snapshots) != 5 {
+			t.Fatal("Snapshots(): Found", len(snapshots), "commits", err)
+		}
+		for _, s := range snapshots[:4] {
+			view, err := factory.At(s)
+			if err != nil {
+				t.Fatal("At():", err)
+			}
+			old := OpenBPlusTree[uint64, uint64](5, cmp.Compare[uint64], view)
+			if ! maps.Equal(maps.Collect(old.All()), commits[s.Seq]) {
+				t.Error("At(): Snapshot", s.Seq, "changed")
+			}
+			old.Put(1000, 1000)
+			if old.Commit() != ErrReadOnly {
+				t.Error("Commit(): Committed a snapshot")
+			}
+		}
+
+		// Only the last commit is kept by Compact
+		compacted := new (memFile)
+		factory, err = factory.Compact(compacted)
+		if err != nil {
+			t.Fatal("Compact():", err)
+		}
+		if compacted.size() >= file.size() {
+			t.Error("Compact(): File did not shrink from", file.size(), "bytes")
+		}
+		tree = OpenBPlusTree[uint64, uint64](5, cmp.Compare[uint64], factory)
+		if err := tree.Validate(); err != nil || ! maps.Equal(maps.Collect(tree.All()), current) {
+			t.Fatal("Compact(): Tree changed", err)
+		}
+		tree.Put(1000, 1000)
+		if err := tree.Commit(); err != nil {
+			t.Fatal("Commit():", err)
+		}
+		if snapshots, _ := factory.Snapshots(); len(snapshots) != 2 {
+			t.Error("Compact(): Kept", len(snapshots) - 2, "older commits")
+		}
+		break
+	}
+}
+
+func TestAppendCommitSize(t *testing.T) {
+	codec := BinaryCodec[uint64]()
+	file := new (memFile)
+	factory, _ := NewAppendFactory(file, cmp.Compare[uint64], codec, codec)
+	tree := OpenBPlusTree[uint64, uint64](8, cmp.Compare[uint64], factory)
+	for j:=uint64(0); j<20000; j++ {
+		tree.Put(j, j)
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatal("Commit():", err)
+	}
+
+	// Small commits write about as many table entries as the nodes they change
+	tables, changed := int64(0), 0
+	for j:=uint64(0); j<200; j++ {
+		tree.Put(j * 97, j)
+		before := maps.Clone(factory.table)
+		if err := tree.Commit(); err != nil {
+			t.Fatal("Commit():", err)
+		}
+		for id, e := range factory.table {
+			if before[id] != e {
+				changed++
+			}
+		}
+		tables += file.size() - factory.last - appendTable
+	}
+	if limit := int64(3 * appendEntry * changed); tables > limit {
+		t.Error("Commit(): Wrote", tables, "bytes of tables for", changed, "changed nodes of", len(factory.table))
+	}
+
+	reopened, err := OpenAppendFactory(file, cmp.Compare[uint64], codec, codec)
+	if err != nil || ! maps.Equal(reopened.table, factory.table) {
+		t.Fatal("OpenAppendFactory(): Table changed", err)
+	}
+}
+
+func TestAppendShares(t *testing.T) {
+	codec := BinaryCodec[uint64]()
+	factory, _ := NewAppendFactory(new (memFile), cmp.Compare[uint64], codec, codec)
+	tree := OpenBPlusTree[uint64, uint64](8, cmp.Compare[uint64], factory)
+	src := rand.NewSource(1)
+	for round := 0; round < 6; round++ {
+		for j := 0; j < 2000; j++ {
+			key := uint64(src.Int63() % 5000)
+			if round > 0 && j % 3 == 2 {
+				tree.Delete(key)
+			}else{
+				tree.Put(key, uint64(round))
+			}
+		}
+		if err := tree.Commit(); err != nil {
+			t.Fatal("Commit():", err)
+		}
+	}
+	tree.Put(2500, 1000)
+	if err := tree.Commit(); err != nil {
+		t.Fatal("Commit():", err)
+	}
+
+	// A node the last commit shares with an older one has the same subtree
+	var subtree func(tree *BPlusTree[uint64, uint64], n Node[uint64, uint64]) [] Entry[uint64, uint64]
+	subtree = func(tree *BPlusTree[uint64, uint64], n Node[uint64, uint64]) [] Entry[uint64, uint64] {
+		m := tree.load(n)
+		if n.isLeaf() {
+			return m.Entries
+		}
+		var entries [] Entry[uint64, uint64]
+		for _, c := range m.Nodes {
+			entries = append(entries, subtree(tree, c)...)
+		}
+		return entries
+	}
+	snapshots, _ := factory.Snapshots()
+	for i, s := range snapshots[1:] {
+		view, err := factory.At(s)
+		if err != nil {
+			t.Fatal("At():", err)
+		}
+		old := OpenBPlusTree[uint64, uint64](8, cmp.Compare[uint64], view)
+		shared := 0
+		for id := range factory.table {
+			n, m := factory.node(id), view.node(id)
+			if ! same(n, m) {
+				continue
+			}
+			shared++
+			if ! slices.Equal(subtree(tree, n), subtree(old, m)) {
+				t.Fatal("AppendFactory: Node", id, "is shared with snapshot", s.Seq, "but its subtree changed")
+			}
+		}
+		if i == 0 && shared < len(factory.table) - tree.height() - 2 {
+			t.Error("AppendFactory: Shared", shared, "of", len(factory.table), "nodes after changing one key")
+		}
+		if same(tree.root, old.root) {
+			t.Error("AppendFactory: Snapshot", s.Seq, "shares the root")
+		}
+	}
+
+	// Uncommitted changes share nothing
+	view, _ := factory.At(snapshots[0])
+	tree.Put(0, 1000)
+	if same(tree.head.Next(), view.node(factory.id(tree.head.Next()))) {
+		t.Error("AppendFactory: Shared a node with uncommitted changes")
+	}
+}
+
+func TestCorruptPage(t *testing.T) {
+	codec := BinaryCodec[uint64]()
+	build := func(factory Committer[uint64, uint64]) *BPlusTree[uint64, uint64] {
+		tree := OpenBPlusTree[uint64, uint64](5, cmp.Compare[uint64], factory)
+		for j:=uint64(0); j<500; j++ {
+			tree.Put(j, j + 1)
+		}
+		if err := tree.Commit(); err != nil {
+			t.Fatal("Commit():", err)
+		}
+		return tree
+	}
+	// Read every key of the tree, which must not panic, and check the error
+	check := func(name string, factory Committer[uint64, uint64], err func() error, page uint32) {
+		tree := OpenBPlusTree[uint64, uint64](5, cmp.Compare[uint64], factory)
+		for j:=uint64(0); j<500; j++ {
+			tree.Get(j)
+			if _, _, ok := tree.Select(int(j)); ok && err() != nil {
+				t.Error(name + ": Select() found an entry after", err())
+			}
+			tree.Rank(j)
+		}
+		if count := tree.CountRange(Unbounded[uint64](), Unbounded[uint64]()); count != 0 {
+			t.Error(name + ": CountRange() counted", count, "keys after", err())
+		}
+		var perr *PageError
+		if ! errors.Is(err(), ErrCorruptPage) || ! errors.As(err(), &perr) || perr.Page != page {
+			t.Error(name + ": Found", err(), "instead of corrupt page", page)
+		}
+	}
+
+	// The last node above the leaves
+	lowest := func(tree *BPlusTree[uint64, uint64]) Node[uint64, uint64] {
+		n := tree.root
+		for ! n.Child(n.Size()).isLeaf() {
+			n = n.Child(n.Size())
+		}
+		return n
+	}
+
+	// A bit flip in the root page and a torn leaf
+	file := new (memFile)
+	pages, _ := NewPageFactory(file, 256, cmp.Compare[uint64], codec, codec)
+	tree := build(pages)
+	leaf, node := pageOf(tree.tail), pageOf(lowest(tree))
+	good := slices.Clone(file.data)
+	root := pages.root
+	file.data[int(root) * 256 + 40] ^= 0x10
+	reopened, err := OpenPageFactory(file, cmp.Compare[uint64], codec, codec)
+	if err != nil {
+		t.Fatal("OpenPageFactory():", err)
+	}
+	check("PageFactory", reopened, reopened.Err, root)
+
+	file.data[int(root) * 256 + 40] ^= 0x10
+	clear(file.data[int(leaf) * 256 + 16:int(leaf) * 256 + 128])
+	reopened, _ = OpenPageFactory(file, cmp.Compare[uint64], codec, codec)
+	check("PageFactory", reopened, reopened.Err, leaf)
+
+	// The first leaf written over the last one and a node a level too high,
+	// both with a checksum that fits
+	file.data = slices.Clone(good)
+	first := pageOf(tree.head)
+	copy(file.data[int(leaf) * 256:int(leaf + 1) * 256], good[int(first) * 256:])
+	reopened, _ = OpenPageFactory(file, cmp.Compare[uint64], codec, codec)
+	check("PageFactory", reopened, reopened.Err, leaf)
+
+	file.data = slices.Clone(good)
+	page := file.data[int(node) * 256:int(node + 1) * 256]
+	page[1]++
+	binary.LittleEndian.PutUint32(page[12:], checksum(page))
+	reopened, _ = OpenPageFactory(file, cmp.Compare[uint64], codec, codec)
+	check("PageFactory", reopened, reopened.Err, node)
+
+	file.data[9] ^= 0x01
+	if _, err := OpenPageFactory(file, cmp.Compare[uint64], codec, codec); err == nil {
+		t.Error("OpenPageFactory(): Opened a file with a corrupt header")
+	}
+
+	// A bit flip in a node of an append only file
+	file = new (memFile)
+	appended, _ := NewAppendFactory(file, cmp.Compare[uint64], codec, codec)
+	tree = build(appended)
+	good = slices.Clone(file.data)
+	e := appended.table[appended.root]
+	file.data[e.offset + 20] ^= 0x01
+	opened, err := OpenAppendFactory(file, cmp.Compare[uint64], codec, codec)
+	if err != nil {
+		t.Fatal("OpenAppendFactory():", err)
+	}
+	check("AppendFactory", opened, opened.Err, appended.root)
+
+	// and a node a level too high
+	file.data = slices.Clone(good)
+	id := appended.id(lowest(tree))
+	e = appended.table[id]
+	image := file.data[e.offset:e.offset + int64(e.length)]
+	image[1]++
+	binary.LittleEndian.PutUint32(image[12:], checksum(image))
+	opened, _ = OpenAppendFactory(file, cmp.Compare[uint64], codec, codec)
+	check("AppendFactory", opened, opened.Err, id)
+}
+
+func TestCorruptWrites(t *testing.T) {
+	codec := BinaryCodec[uint64]()
+	build := func(factory Committer[uint64, uint64]) Node[uint64, uint64] {
+		tree := OpenBPlusTree[uint64, uint64](5, cmp.Compare[uint64], factory)
+		for j:=uint64(0); j<3000; j++ {
+			tree.Put(j, j + 1)
+		}
+		if err := tree.Commit(); err != nil {
+			t.Fatal("Commit():", err)
+		}
+		// A node below the root, not on the way to the first and last keys
+		return tree.load(tree.root).Nodes[1]
+	}
+	// Keep changing the tree, which must not panic and must not commit
+	write := func(name string, factory Committer[uint64, uint64]) {
+		tree := OpenBPlusTree[uint64, uint64](5, cmp.Compare[uint64], factory)
+		for j:=uint64(0); j<3000; j++ {
+			if j % 2 == 0 {
+				tree.Delete(j)
+			}else{
+				tree.Put(j + 5000, j)
+			}
+		}
+		batch := new (Batch[uint64, uint64])
+		batch.Put(1, 1)
+		tree.ApplyBatch(batch)
+		tree.DeleteRange(Inclusive[uint64](10), Inclusive[uint64](2000))
+		if ! errors.Is(tree.Err(), ErrCorruptPage) {
+			t.Error(name + ": Err() is", tree.Err())
+		}
+		if err := tree.Commit(); ! errors.Is(err, ErrCorruptPage) {
+			t.Error(name + ": Commit() after a corrupt page returned", err)
+		}
+	}
+	// The file still holds the 3000 keys of the first commit
+	verify := func(name string, factory Committer[uint64, uint64]) {
+		tree := OpenBPlusTree[uint64, uint64](5, cmp.Compare[uint64], factory)
+		if err := tree.Validate(); err != nil || tree.Err() != nil || tree.Len() != 3000 {
+			t.Fatal(name + ": Reopened", tree.Len(), "keys", err, tree.Err())
+		}
+		for j:=uint64(0); j<3000; j++ {
+			if tree.Get(j) != j + 1 {
+				t.Fatal(name + ": Get(): Lost key", j)
+			}
+		}
+	}
+
+	// A page file whose root was changed
+	file := new (memFile)
+	pages, _ := NewPageFactory(file, 256, cmp.Compare[uint64], codec, codec)
+	build(pages)
+	root := int(pages.root) * 256 + 40
+	file.data[root] ^= 0x10
+	reopened, _ := OpenPageFactory(file, cmp.Compare[uint64], codec, codec)
+	tree := OpenBPlusTree[uint64, uint64](5, cmp.Compare[uint64], reopened)
+	tree.Put(7, 7)
+	if err := tree.Commit(); err == nil {
+		t.Error("Commit(): Committed over a corrupt root")
+	}
+	file.data[root] ^= 0x10
+	reopened, _ = OpenPageFactory(file, cmp.Compare[uint64], codec, codec)
+	verify("PageFactory", reopened)
+
+	// A page file with a changed internal node
+	child := int(pageOf(build(pages))) * 256 + 40
+	file.data[child] ^= 0x10
+	reopened, _ = OpenPageFactory(file, cmp.Compare[uint64], codec, codec)
+	write("PageFactory", reopened)
+	file.data[child] ^= 0x10
+	reopened, _ = OpenPageFactory(file, cmp.Compare[uint64], codec, codec)
+	verify("PageFactory", reopened)
+
+	// An append only file with a changed internal node
+	file = new (memFile)
+	appended, _ := NewAppendFactory(file, cmp.Compare[uint64], codec, codec)
+	e := appended.table[appended.id(build(appended))]
+	file.data[e.offset + 20] ^= 0x01
+	opened, _ := OpenAppendFactory(file, cmp.Compare[uint64], codec, codec)
+	write("AppendFactory", opened)
+	file.data[e.offset + 20] ^= 0x01
+	opened, _ = OpenAppendFactory(file, cmp.Compare[uint64], codec, codec)
+	verify("AppendFactory", opened)
 }
-*/
\ No newline at end of file
@@ -0,0 +1,236 @@
+// Package btreetest runs a tree that implements btree.Treelike against a map
+// to find the operations where the two disagree. Keys and values of any
+// comparable type are made by a Generator, Uint64s makes the ones the
+// package tests use.
+package btreetest
+
+import (
+	"cmp"
+	"math/rand"
+	"testing"
+	"time"
+	"lifealgorithmic.com/btree"
+)
+
+// Fail the test when tree.Validate finds a problem
+func Check[K any, V any](t testing.TB, tree btree.Treelike[K, V]) {
+	if err := tree.Validate(); err != nil {
+		t.Error("Check:", err)
+		t.FailNow()
+	}
+}
+
+// Wrap tree so that every operation on it is checked against a map
+func NewBtreeTest[K cmp.Ordered, V comparable](t *testing.T, tree btree.Treelike[K, V]) *BtreeTest[K, V] {
+	return NewBtreeTestFunc(t, tree, cmp.Compare[K])
+}
+
+// Wrap a tree ordered by compare so that every operation on it is checked
+// against a map
+func NewBtreeTestFunc[K comparable, V comparable](t *testing.T, tree btree.Treelike[K, V], compare func(a, b K) int) *BtreeTest[K, V] {
+	test := new (BtreeTest[K, V])
+	test.test = t
+	test.reference = make (map[K] V)
+	test.tree = tree
+	test.compare = compare
+	return test
+}
+
+// A tree paired with a map that holds what the tree should hold
+type BtreeTest[K comparable, V comparable] struct {
+	test *testing.T
+	tree btree.Treelike[K, V]
+	reference map[K] V
+	compare func(a, b K) int
+}
+
+// Makes the keys and values of RandomTestWith and SetupBenchmarkWith. Key
+// makes a random key from src, Value the value of the nth insertion and
+// Compare orders the keys like the tree does.
+type Generator[K any, V any] struct {
+	Key func(src rand.Source) K
+	Value func(n int) V
+	Compare func(a, b K) int
+}
+
+// Random uint64 keys with the insertion count as the value
+func Uint64s() Generator[uint64, int] {
+	return Generator[uint64, int]{
+		Key: func(src rand.Source) uint64 {
+			return uint64(src.Int63())
+		},
+		Value: func(n int) int {
+			return n
+		},
+		Compare: cmp.Compare[uint64],
+	}
+}
+
+func (self *BtreeTest[K, V]) Put(key K, value V) {
+	refval, refok := self.reference[key]
+	self.reference[key] = value
+	old, replaced := self.tree.Swap(key, value)
+	if old != refval || replaced != refok {
+		self.test.Error("Swap(): Mismatch:", old, replaced, "!=", refval, refok)
+	}
+	if self.tree.Len() != len(self.reference) {
+		self.test.Error("Put(): Len() mismatch:", self.tree.Len(), "!=", len(self.reference))
+		self.test.FailNow()
+	}
+	if found, ok := self.tree.GetOK(key); !ok || self.reference[key] != found {
+		self.test.Error("Put(): Mismatch:", found, ok, "!=", self.reference[key])
+		self.test.FailNow()
+	} 
+	Check(self.test, self.tree)
+}
+
+func (self *BtreeTest[K, V]) Get(key K) V {
+	value := self.tree.Get(key)
+	if (value != self.reference[key]) {
+		self.test.Error("Fetch(): Mismatch:", value, "!=", self.reference[key])
+	}
+	return value
+}
+
+func (self *BtreeTest[K, V]) GetOK(key K) (V, bool) {
+	value, ok := self.tree.GetOK(key)
+	refval, refok := self.reference[key]
+	if (value != refval || ok != refok) {
+		self.test.Error("GetOK(): Mismatch:", value, ok, "!=", refval, refok)
+	}
+	return value, ok
+}
+
+func (self *BtreeTest[K, V]) Has(key K) bool {
+	_, ok := self.GetOK(key)
+	return ok
+}
+
+func (self *BtreeTest[K, V]) Delete(key K) {
+	refval, refok := self.reference[key]
+	delete(self.reference, key)
+	old, found := self.tree.Delete(key)
+	if old != refval || found != refok {
+		self.test.Error("Delete(): Mismatch:", old, found, "!=", refval, refok)
+	}
+	if self.tree.Len() != len(self.reference) {
+		self.test.Error("Delete(): Len() mismatch:", self.tree.Len(), "!=", len(self.reference))
+		self.test.FailNow()
+	}
+	
+	if self.tree.Has(key) {
+		self.test.Error("Delete(): Value was not deleted:", key)
+		self.test.FailNow()
+	}
+	Check(self.test, self.tree)
+}
+
+func (self *BtreeTest[K, V]) Iterate() chan btree.Entry[K, V] {
+	rval := make (chan btree.Entry[K, V]) 
+	treechan := self.tree.Iterate()
+	if treechan == nil {
+		return nil
+	}
+	checker := func() {
+		var lastkey K
+		var checklast bool = false
+		for entry := range treechan {
+			if (checklast) {
+				if self.compare(entry.Key, lastkey) < 0 {
+					self.test.Error("Iterate(): Values are not increasing:", entry.Key, ">=", lastkey)
+				}
+			}
+			checklast = true
+			lastkey = entry.Key
+			
+			refval, ok := self.reference[entry.Key]
+			if (!ok) {
+				self.test.Error("Iterate(): Iteration produced a false key:", entry.Key)
+			}
+			
+			if refval != entry.Value {
+				self.test.Error("Iterate(): Iteration discovered a false value:", entry.Value)
+			}
+			
+			rval <- entry
+		}
+		close(rval)
+	}
+	go checker()
+	return rval
+}
+
+// Insert random keys, and delete random earlier keys, for several iterations
+// then iterate the tree. Every operation is checked against a map.
+func RandomTest(t *testing.T, tree btree.Treelike[uint64, int], seed int64, iterations int, insertions int) {
+	RandomTestWith(t, tree, Uint64s(), seed, iterations, insertions)
+}
+
+// RandomTest for a tree of any keys and values, made by gen
+func RandomTestWith[K comparable, V comparable](t *testing.T, tree btree.Treelike[K, V], gen Generator[K, V], seed int64, iterations int, insertions int) {
+	deletions := 5 * insertions
+	t.Log("Random seed:", seed)
+
+	src := rand.NewSource(seed)
+	test := NewBtreeTestFunc(t, tree, gen.Compare)
+
+	keys := make([] K, insertions*iterations, insertions*iterations);
+
+	for i:=0; i<iterations; i++ {
+
+		for j:=0; j<insertions; j++ {
+			key := gen.Key(src)
+			keys[(insertions*i)+j] = key			
+			test.Put(key, gen.Value(j))
+			// Double insert every so often...
+			if (j % 100) == 10 {
+				test.Put(key, gen.Value(j+1))
+			}
+		}
+	
+		for j:=0; j<deletions; j++ {
+			listindex := uint(src.Int63()) % uint(insertions*(i+1))
+			test.Delete(keys[listindex])
+		}
+	}
+	
+	ch := test.Iterate()
+	if ch != nil {
+		count := 0
+		for entry := range ch {
+			value := test.reference[entry.Key]
+			if (value != entry.Value) {
+				t.Error("Iterate(): Iteration discovered a false value:", entry.Value)
+				t.FailNow()
+			}
+			count += 1
+		}
+		if count == 0 {
+			test.test.Error("Iteration produced no items!")
+		}
+	}
+}
+
+// Fill tree with random keys, returns the seed and the source that made them
+func SetupBenchmark(b *testing.B, tree btree.Treelike[uint64, int]) (int64, rand.Source) {
+	return SetupBenchmarkWith(b, tree, Uint64s())
+}
+
+// SetupBenchmark for a tree of any keys and values, made by gen
+func SetupBenchmarkWith[K any, V any](b *testing.B, tree btree.Treelike[K, V], gen Generator[K, V]) (int64, rand.Source) {
+	prefill := 3000000
+	seed := time.Now().UnixNano()
+	src := rand.NewSource(seed)
+
+	/*
+	if prefill < b.N {
+		prefill = b.N
+	}
+	*/
+	
+	for j:=0; j<prefill; j++ {
+		tree.Put(gen.Key(src), gen.Value(j))
+	}	
+
+	return seed, src
+}
@@ -0,0 +1,179 @@
+package btree
+
+import (
+	"errors"
+)
+
+var ErrPoolFull = errors.New("btree: every page in the buffer pool is pinned")
+
+// The capacity in bytes of the buffer pool of a new PageFactory
+const DefaultPoolSize = 1 << 20
+
+// Counts kept by a BufferPool
+type PoolStats struct {
+	Hits int
+	Misses int
+	Evictions int
+	Writes int
+}
+
+// A page held in a BufferPool
+type frame struct {
+	page uint32
+	data [] byte
+	pins int
+	dirty bool
+	used bool
+}
+
+// A BufferPool keeps pages of a PageFile in memory. A page is pinned while
+// it is in use and can't be evicted until it is unpinned again, changed pages
+// are written back when they are evicted or flushed. Pages are evicted with
+// the CLOCK algorithm: the hand passes over the frames, clearing the used bit
+// of each and taking the first unpinned frame that wasn't used since the hand
+// last passed.
+type BufferPool struct {
+	file PageFile
+	size int
+	capacity int
+	frames [] *frame
+	pages map[uint32] *frame
+	hand int
+	frozen bool
+	Stats PoolStats
+}
+
+// A pool for pages of size bytes that holds up to capacity bytes, but at
+// least one page
+func NewBufferPool(file PageFile, size int, capacity int) *BufferPool {
+	return &BufferPool{file: file, size: size, capacity: max(capacity / size, 1), pages: map[uint32] *frame {}}
+}
+
+// Pin page in the pool and return its bytes. They stay valid until the page
+// is unpinned and changes to them must be reported to Unpin.
+func (self *BufferPool) Pin(page uint32) ([] byte, error) {
+	return self.pin(page, true)
+}
+
+// Unpin a page pinned by Pin, dirty tells if its bytes were changed
+func (self *BufferPool) Unpin(page uint32, dirty bool) {
+	f, ok := self.pages[page]
+	if ! ok || f.pins == 0 {
+		return
+	}
+	f.pins--
+	f.dirty = f.dirty || dirty
+}
+
+// Write every dirty page back to the file
+func (self *BufferPool) Flush() error {
+	for _, f := range self.frames {
+		if self.pages[f.page] == f {
+			if err := self.flush(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Change the capacity in bytes, unpinned pages are written back and dropped
+// until the pool fits. Pinned pages stay until they are evicted later.
+func (self *BufferPool) SetCapacity(capacity int) error {
+	self.capacity = max(capacity / self.size, 1)
+	excess := len(self.frames) - self.capacity
+	kept := self.frames[:0]
+	for _, f := range self.frames {
+		if excess > 0 && f.pins == 0 && ! (self.frozen && f.dirty) {
+			if self.pages[f.page] == f {
+				if err := self.flush(f); err != nil {
+					return err
+				}
+				delete(self.pages, f.page)
+			}
+			excess--
+			continue
+		}
+		kept = append(kept, f)
+	}
+	clear(self.frames[len(kept):])
+	self.frames = kept
+	self.hand = 0
+	return nil
+}
+
+// Pin page, only reading it from the file if read is true. Otherwise the
+// page is zeroed for the caller to write all of it.
+func (self *BufferPool) pin(page uint32, read bool) ([] byte, error) {
+	if f, ok := self.pages[page]; ok {
+		self.Stats.Hits++
+		f.pins++
+		f.used = true
+		if ! read {
+			clear(f.data)
+		}
+		return f.data, nil
+	}
+	self.Stats.Misses++
+	f, err := self.victim()
+	if err != nil {
+		return nil, err
+	}
+	if read {
+		if _, err := self.file.ReadAt(f.data, int64(page) * int64(self.size)); err != nil {
+			return nil, err
+		}
+	}else{
+		clear(f.data)
+	}
+	f.page, f.pins, f.dirty, f.used = page, 1, false, true
+	self.pages[page] = f
+	return f.data, nil
+}
+
+// Stop writing pages back to the file. Changed pages stay in the pool, which
+// can fill up with them.
+func (self *BufferPool) freeze() {
+	self.frozen = true
+}
+
+// Find a frame for a new page, a frame is free when the pool doesn't map its
+// page to it
+func (self *BufferPool) victim() (*frame, error) {
+	if len(self.frames) < self.capacity {
+		f := &frame{data: make ([] byte, self.size)}
+		self.frames = append(self.frames, f)
+		return f, nil
+	}
+	for i := 0; i < 2 * len(self.frames); i++ {
+		f := self.frames[self.hand]
+		self.hand = (self.hand + 1) % len(self.frames)
+		switch {
+		case self.pages[f.page] != f:
+			return f, nil
+		case f.pins > 0 || self.frozen && f.dirty:
+		case f.used:
+			f.used = false
+		default:
+			if err := self.flush(f); err != nil {
+				return nil, err
+			}
+			delete(self.pages, f.page)
+			self.Stats.Evictions++
+			return f, nil
+		}
+	}
+	return nil, ErrPoolFull
+}
+
+func (self *BufferPool) flush(f *frame) error {
+	if ! f.dirty || self.frozen {
+		return nil
+	}
+	if _, err := self.file.WriteAt(f.data, int64(f.page) * int64(self.size)); err != nil {
+		return err
+	}
+	f.dirty = false
+	self.Stats.Writes++
+	return nil
+}
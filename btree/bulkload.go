@@ -0,0 +1,227 @@
+package btree
+
+import (
+	"errors"
+	"iter"
+)
+
+var (
+	ErrNotEmpty = errors.New("btree: bulk load into a tree that is not empty")
+	ErrNotSorted = errors.New("btree: bulk load keys are not strictly increasing")
+)
+
+// Build the tree from entries sorted by strictly increasing key. Leaves are
+// filled left to right to fill * N entries (but never less than N/2) and the
+// internal levels are built on top of them. The tree must be empty, it is
+// left empty when an error is returned.
+func (self *BPlusTree[K, V]) BulkLoad(seq iter.Seq2[K, V], fill float64) error {
+	if self.size != 0 {
+		return ErrNotEmpty
+	}
+	if err := self.Err(); err != nil {
+		return err
+	}
+	target := fillTarget(self.N, fill)
+
+	// The leaf level is streamed, the previous leaf is held back so that it
+	// can share its entries with an underfull last leaf.
+	var level [] *MemNode[K, V]
+	var prev, cur *MemNode[K, V]
+	var err error
+	count := 0
+	for key, value := range seq {
+		if cur != nil && len(cur.Entries) > 0 && self.compare(cur.Entries[len(cur.Entries)-1].Key, key) >= 0 {
+			err = ErrNotSorted
+			break
+		}
+		if cur == nil || len(cur.Entries) == target {
+			if prev != nil {
+				self.store(prev)
+				level = append(level, prev)
+			}
+			prev = cur
+			cur = self.blank(self.factory.NewLeaf(self.N))
+			if prev != nil {
+				prev.neighbor = cur.ref
+				cur.previous = prev.ref
+			}
+		}
+		cur.Entries = append(cur.Entries, Entry[K, V]{key, value})
+		count++
+	}
+	if err != nil {
+		for _, m := range append(level, prev, cur) {
+			if m != nil {
+				self.factory.Release(m.ref)
+			}
+		}
+		return err
+	}
+	if cur == nil {
+		return nil
+	}
+	if prev != nil && len(cur.Entries) < self.N/2 {
+		entries := append(prev.Entries, cur.Entries...)
+		if len(entries) <= self.N {
+			prev.Entries = entries
+			prev.neighbor = nil
+			self.factory.Release(cur.ref)
+			cur, prev = prev, nil
+		}else{
+			half := len(entries) / 2
+			prev.Entries = entries[:half]
+			cur.Entries = append(make ([] Entry[K, V], 0, self.N), entries[half:]...)
+		}
+	}
+	for _, m := range [] *MemNode[K, V] {prev, cur} {
+		if m != nil {
+			self.store(m)
+			level = append(level, m)
+		}
+	}
+
+	self.release(self.root)
+	self.head = level[0].ref
+	self.tail = level[len(level)-1].ref
+	self.size = count
+
+	// Build the internal levels bottom up, keeping the first key under each
+	// node for the separators of the level above.
+	firsts := make ([] K, len(level))
+	for i, m := range level {
+		firsts[i] = m.Entries[0].Key
+	}
+	for len(level) > 1 {
+		var up [] *MemNode[K, V]
+		var upfirsts [] K
+		start := 0
+		for _, size := range chunks(len(level), target + 1, self.N/2 + 1) {
+			m := self.blank(self.factory.NewNode(self.N))
+			for i := start; i < start + size; i++ {
+				if i > start {
+					m.Entries = append(m.Entries, Entry[K, V]{Key: firsts[i]})
+				}
+				m.Nodes = append(m.Nodes, level[i].ref)
+				m.Counts = append(m.Counts, self.total(level[i]))
+			}
+			self.store(m)
+			up = append(up, m)
+			upfirsts = append(upfirsts, firsts[start])
+			start += size
+		}
+		level, firsts = up, upfirsts
+	}
+	self.root = level[0].ref
+	return nil
+}
+
+// Build the tree from entries sorted by strictly increasing key, filling
+// nodes to fill * N values (but never less than N/2). The tree must be empty,
+// it is left unchanged when an error is returned.
+func (tree *BTree[K, V]) BulkLoad(seq iter.Seq2[K, V], fill float64) error {
+	if tree.Stats.Size != 0 {
+		return ErrNotEmpty
+	}
+	var pairs [] Pair[K, V]
+	for key, value := range seq {
+		if len(pairs) > 0 && tree.compare(pairs[len(pairs)-1].key, key) >= 0 {
+			return ErrNotSorted
+		}
+		pairs = append(pairs, Pair[K, V]{key, value})
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	// Values held by a subtree of height h when every node holds per values
+	capacity := func(per, h int) int {
+		total := per
+		for ; h > 0; h-- {
+			total = per + (per + 1) * total
+		}
+		return total
+	}
+	target := fillTarget(tree.N, fill)
+	full := func(h int) int { return capacity(tree.N, h) }
+	least := func(h int) int { return capacity(tree.N/2, h) }
+	
+	// The lowest tree that holds everything at the target fill, unless the
+	// root would be left with too few values for two children
+	height := 0
+	for capacity(target, height) < len(pairs) {
+		height++
+	}
+	if height > 0 && len(pairs) < 2 * least(height-1) + 1 {
+		height--
+	}
+
+	tree.Stats.Nodes = 0
+	tree.Stats.Leaves = 0
+	var build func (pairs [] Pair[K, V], h int, fewest int) *bNode[K, V]
+	build = func (pairs [] Pair[K, V], h int, fewest int) *bNode[K, V] {
+		n := new(bNode[K, V])
+		n.Values = make ([] Pair[K, V], 0, tree.N+1)
+		if h == 0 {
+			n.Values = append(n.Values, pairs...)
+			tree.Stats.Leaves++
+			return n
+		}
+		tree.Stats.Nodes++
+		
+		// Pick a number of children close to the target fill that keeps
+		// this node and every child between half and completely full
+		slots := len(pairs) + 1
+		children := ceilDiv(slots, capacity(target, h-1) + 1)
+		children = max(children, ceilDiv(slots, full(h-1) + 1), fewest)
+		children = min(children, slots / (least(h-1) + 1), tree.N + 1)
+		
+		n.Nodes = make ([] *bNode[K, V], 0, tree.N+2)
+		start := 0
+		for i, size := range spread(len(pairs) - (children - 1), children) {
+			if i > 0 {
+				n.Values = append(n.Values, pairs[start])
+				start++
+			}
+			n.Nodes = append(n.Nodes, build(pairs[start:start+size], h-1, tree.N/2 + 1))
+			start += size
+		}
+		return n
+	}
+	tree.root = build(pairs, height, 2)
+	tree.Stats.Depth = height
+	tree.Stats.Size = len(pairs)
+	return nil
+}
+
+// The number of values in a bulk loaded node
+func fillTarget(order int, fill float64) int {
+	target := int(fill * float64(order) + 0.999999)
+	return min(max(target, order/2, 1), order)
+}
+
+// Split total items into groups of about target items, but no fewer than
+// least items unless there is only one group. Groups never exceed target or
+// 2 * least - 1, whichever is larger.
+func chunks(total, target, least int) [] int {
+	count := ceilDiv(total, target)
+	if total >= least {
+		count = min(count, total / least)
+	}
+	return spread(total, max(count, 1))
+}
+
+// Split total items into count groups that differ in size by at most one
+func spread(total, count int) [] int {
+	sizes := make ([] int, count)
+	for i := range sizes {
+		sizes[i] = total / count
+		if i < total % count {
+			sizes[i]++
+		}
+	}
+	return sizes
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,122 @@
+package btree
+
+// A Cursor is a position in the leaves of a BPlusTree. The cursor holds a
+// copy of the leaf it is positioned on, so it must be repositioned (with 
+// Seek, First or Last) after the tree is modified.
+type Cursor[K any, V any] struct {
+	tree * BPlusTree[K, V]
+	leaf * MemNode[K, V]
+	pos int
+}
+
+// Return an unpositioned cursor
+func (self *BPlusTree[K, V]) Cursor() *Cursor[K, V] {
+	return &Cursor[K, V]{tree: self}
+}
+
+// True when the cursor is positioned on an entry
+func (self *Cursor[K, V]) Valid() bool {
+	return self.leaf != nil
+}
+
+// The key at the cursor or the zero value when the cursor is not valid
+func (self *Cursor[K, V]) Key() K {
+	if self.leaf == nil {
+		var none K
+		return none
+	}
+	return self.leaf.Entries[self.pos].Key
+}
+
+// The value at the cursor or the zero value when the cursor is not valid
+func (self *Cursor[K, V]) Value() V {
+	if self.leaf == nil {
+		var none V
+		return none
+	}
+	return self.leaf.Entries[self.pos].Value
+}
+
+// Position on the first entry with a key greater than or equal to key
+func (self *Cursor[K, V]) Seek(key K) bool {
+	n := self.tree.root
+	for ! n.isLeaf() {
+		pos, _ := n.Find(key)
+		n = n.Child(pos)
+	}
+	pos, match := n.Find(key)
+	if match {
+		pos = pos - 1
+	}
+	return self.forward(self.tree.load(n), pos)
+}
+
+// Position on the smallest key in the tree
+func (self *Cursor[K, V]) First() bool {
+	return self.forward(self.tree.load(self.tree.head), 0)
+}
+
+// Position on the largest key in the tree
+func (self *Cursor[K, V]) Last() bool {
+	leaf := self.tree.load(self.tree.tail)
+	return self.backward(leaf, len(leaf.Entries) - 1)
+}
+
+// Step to the next entry, the cursor becomes invalid after the last entry
+func (self *Cursor[K, V]) Next() bool {
+	if self.leaf == nil {
+		return false
+	}
+	return self.forward(self.leaf, self.pos + 1)
+}
+
+// Step to the previous entry, the cursor becomes invalid before the first entry
+func (self *Cursor[K, V]) Prev() bool {
+	if self.leaf == nil {
+		return false
+	}
+	return self.backward(self.leaf, self.pos - 1)
+}
+
+// Position on pos in leaf, following the neighbor chain past the end of a leaf
+func (self *Cursor[K, V]) forward(leaf *MemNode[K, V], pos int) bool {
+	for pos >= len(leaf.Entries) {
+		if leaf.neighbor == nil {
+			self.leaf = nil
+			return false
+		}
+		leaf = self.tree.load(leaf.neighbor)
+		pos = 0
+	}
+	self.leaf, self.pos = leaf, pos
+	return true
+}
+
+// Position on pos in leaf, following the previous chain before the start of a leaf
+func (self *Cursor[K, V]) backward(leaf *MemNode[K, V], pos int) bool {
+	for pos < 0 {
+		if leaf.previous == nil {
+			self.leaf = nil
+			return false
+		}
+		leaf = self.tree.load(leaf.previous)
+		pos = len(leaf.Entries) - 1
+	}
+	self.leaf, self.pos = leaf, pos
+	return true
+}
+
+// Position on the last entry with a key less than key (or equal to key when
+// inclusive)
+func (self *Cursor[K, V]) before(key K, inclusive bool) bool {
+	n := self.tree.root
+	for ! n.isLeaf() {
+		pos, _ := n.Find(key)
+		n = n.Child(pos)
+	}
+	pos, match := n.Find(key)
+	if match && ! inclusive {
+		pos = pos - 1
+	}
+	return self.backward(self.tree.load(n), pos - 1)
+}
@@ -0,0 +1,138 @@
+package btree
+
+import (
+	"iter"
+)
+
+// The kinds of Change
+const (
+	Added = iota + 1
+	Removed
+	Changed
+)
+
+// An entry that differs between two trees, Old is the value in the first tree
+// and New the value in the second
+type Change[K any, V any] struct {
+	Kind int
+	Key K
+	Old V
+	New V
+}
+
+// The entries added, removed or changed going from tree a to tree b, in key
+// order. Subtrees that both trees share are skipped without being read, those
+// are the nodes they both hold and the nodes two commits of an AppendFactory
+// have in common.
+func Diff[K any, V comparable](a, b *BPlusTree[K, V]) iter.Seq[Change[K, V]] {
+	return DiffFunc(a, b, func(x, y V) bool {
+		return x == y
+	})
+}
+
+// Diff for values that are compared with equal
+func DiffFunc[K any, V any](a, b *BPlusTree[K, V], equal func(x, y V) bool) iter.Seq[Change[K, V]] {
+	return func(yield func(Change[K, V]) bool) {
+		wa, wb := a.walker(), b.walker()
+		for {
+			// Line up the subtrees and skip the shared ones
+			if len(wa.leaf) == 0 && len(wb.leaf) == 0 && len(wa.stack) > 0 && len(wb.stack) > 0 {
+				ta, tb := wa.stack[len(wa.stack)-1], wb.stack[len(wb.stack)-1]
+				switch {
+				case same(ta.node, tb.node):
+					wa.stack = wa.stack[:len(wa.stack)-1]
+					wb.stack = wb.stack[:len(wb.stack)-1]
+				case ta.height > tb.height:
+					wa.expand()
+				case ta.height < tb.height:
+					wb.expand()
+				default:
+					wa.expand()
+					wb.expand()
+				}
+				continue
+			}
+			wa.fill()
+			wb.fill()
+
+			var change Change[K, V]
+			order := 0
+			switch {
+			case len(wa.leaf) == 0 && len(wb.leaf) == 0:
+				return
+			case len(wb.leaf) == 0:
+				order = -1
+			case len(wa.leaf) == 0:
+				order = 1
+			default:
+				order = a.compare(wa.leaf[0].Key, wb.leaf[0].Key)
+			}
+			switch {
+			case order < 0:
+				change = Change[K, V]{Kind: Removed, Key: wa.leaf[0].Key, Old: wa.leaf[0].Value}
+				wa.leaf = wa.leaf[1:]
+			case order > 0:
+				change = Change[K, V]{Kind: Added, Key: wb.leaf[0].Key, New: wb.leaf[0].Value}
+				wb.leaf = wb.leaf[1:]
+			default:
+				change = Change[K, V]{Kind: Changed, Key: wa.leaf[0].Key, Old: wa.leaf[0].Value, New: wb.leaf[0].Value}
+				wa.leaf = wa.leaf[1:]
+				wb.leaf = wb.leaf[1:]
+				if equal(change.Old, change.New) {
+					continue
+				}
+			}
+			if ! yield(change) {
+				return
+			}
+		}
+	}
+}
+
+// Whether two nodes hold the same subtree
+func same[K any, V any](a, b Node[K, V]) bool {
+	if a == b {
+		return true
+	}
+	s, ok := a.(sharer[K, V])
+	return ok && s.shares(b)
+}
+
+// A walk over the entries of a tree that can step over whole subtrees. The
+// stack holds the subtrees still to visit with the next one on top, leaf the
+// entries left in the current leaf, which come before the stack.
+type walker[K any, V any] struct {
+	tree *BPlusTree[K, V]
+	stack [] subtree[K, V]
+	leaf [] Entry[K, V]
+}
+
+type subtree[K any, V any] struct {
+	node Node[K, V]
+	height int
+}
+
+func (self *BPlusTree[K, V]) walker() *walker[K, V] {
+	return &walker[K, V]{tree: self, stack: [] subtree[K, V] {{self.root, self.height()}}}
+}
+
+// Replace the next subtree by its children, or by its entries for a leaf
+func (self *walker[K, V]) expand() {
+	top := self.stack[len(self.stack)-1]
+	self.stack = self.stack[:len(self.stack)-1]
+	m := self.tree.load(top.node)
+	if top.height == 0 {
+		self.leaf = m.Entries
+		return
+	}
+	for i := len(m.Nodes) - 1; i >= 0; i-- {
+		self.stack = append(self.stack, subtree[K, V]{m.Nodes[i], top.height - 1})
+	}
+}
+
+// Descend until there are entries to read or nothing is left
+func (self *walker[K, V]) fill() {
+	for len(self.leaf) == 0 && len(self.stack) > 0 {
+		self.expand()
+	}
+}
@@ -1,33 +1,64 @@
 package btree
 
-import (
-	"testing"
-)
+type NodeFactory[K any, V any] interface {
+	NewNode(order int) Node[K, V]
+	NewLeaf(order int) Node[K, V]
+	Release(Node[K, V])
+}
+
+// A NodeFactory that keeps the tree after the program ends. Commit makes the
+// changes so far durable with root as the root of the tree, Root returns the
+// root of the last commit or nil when nothing was committed.
+type Committer[K any, V any] interface {
+	NodeFactory[K, V]
+	Commit(root Node[K, V]) error
+	Root() Node[K, V]
+}
+
+// A NodeFactory that can fail to read or write a node keeps the first error
+// and returns it from Err
+type failer interface {
+	Err() error
+}
 
-type NodeFactory interface {
-	NewNode(order int) Node
-	NewLeaf(order int) Node
-	Release(Node)
+// A node that can tell when a node of another tree holds the same subtree
+type sharer[K any, V any] interface {
+	shares(n Node[K, V]) bool
 }
 
-type Entry struct {
-	Key interface{}
-	Value interface{}
+type Entry[K any, V any] struct {
+	Key K
+	Value V
 }
 
-type Treelike interface {
-	Put(interface{}, interface{})
-	Get(interface{}) interface{}
-	Delete(interface{})
-	Iterate() chan Entry
-	Check(*testing.T) 
+// The operations both trees share, Validate checks the structure of the tree
+// (see btreetest for a harness that runs a Treelike against a map)
+type Treelike[K any, V any] interface {
+	Put(K, V)
+	Swap(K, V) (V, bool)
+	Get(K) V
+	GetOK(K) (V, bool)
+	Has(K) bool
+	Delete(K) (V, bool)
+	Len() int
+	Iterate() chan Entry[K, V]
+	Validate() error
 }
 
-type Node interface {
+// Find returns the position of the first key greater than the argument and
+// whether the key before that position is a match. Child and Value read the
+// link or the value at a position returned by Find, Count is the number of 
+// entries under the link. Size is the number of keys in the node.
+type Node[K any, V any] interface {
 	isLeaf() bool
-	Find(interface{}) (int, bool, interface{})
-	Load(* MemNode) 
-	Store(* MemNode)
-	Dump(chan Entry)
-	Next() Node
+	Find(K) (int, bool)
+	Size() int
+	Child(int) Node[K, V]
+	Count(int) int
+	Value(int) V
+	Load(* MemNode[K, V]) 
+	Store(* MemNode[K, V])
+	Dump(chan Entry[K, V])
+	Next() Node[K, V]
+	Prev() Node[K, V]
 }
@@ -0,0 +1,561 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+var (
+	ErrPageSize = errors.New("btree: page is too small for the node")
+	ErrCodec = errors.New("btree: codec does not have a fixed size")
+	ErrNotPageFile = errors.New("btree: file does not start with a page file header")
+	ErrCorruptPage = errors.New("btree: corrupt page")
+)
+
+// The page or node that could not be read, Err is ErrCorruptPage when the
+// page was torn or changed
+type PageError struct {
+	Page uint32
+	Err error
+}
+
+func (self *PageError) Error() string {
+	return fmt.Sprintf("%v %d", self.Err, self.Page)
+}
+
+func (self *PageError) Unwrap() error {
+	return self.Err
+}
+
+// A Codec stores a value in a fixed number of bytes
+type Codec[T any] interface {
+	Size() int
+	Encode(buf []byte, value T)
+	Decode(buf []byte) T
+}
+
+type binaryCodec[T any] struct {
+}
+
+// A Codec for the fixed size types that encoding/binary handles: numbers,
+// and arrays and structs of them. Size is negative for other types.
+func BinaryCodec[T any]() Codec[T] {
+	return binaryCodec[T]{}
+}
+
+func (self binaryCodec[T]) Size() int {
+	var value T
+	return binary.Size(value)
+}
+
+func (self binaryCodec[T]) Encode(buf []byte, value T) {
+	binary.Encode(buf, binary.LittleEndian, value)
+}
+
+func (self binaryCodec[T]) Decode(buf []byte) (value T) {
+	binary.Decode(buf, binary.LittleEndian, &value)
+	return
+}
+
+// Where a PageFactory keeps its pages, an *os.File will do
+type PageFile interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Page 0 of the file is the file header:
+//
+//	magic [4]byte, version, page size, checksum, pages in the file, first free page, root uint32
+//
+// every other page is a node or on the free list:
+//
+//	kind byte, level byte, keys uint16, neighbor, previous, checksum, page uint32
+//	leaf: keys * (key, value)
+//	node: keys * key, keys+1 * page uint32, keys+1 * count uint64
+//	free: the next free page is the neighbor
+//
+// The checksum of every page is the CRC32C of the page without the checksum.
+// A node page holds its own number, so one written to the wrong place is
+// caught. Leaves are at level 0 and their parents at level 1.
+const (
+	pageMagic = "BTPF"
+	pageVersion = 4
+	pageHeader = 20
+)
+
+const (
+	pageFree = iota
+	pageLeaf
+	pageNode
+)
+
+// A NodeFactory that keeps nodes in fixed size pages of a file. Pages are
+// read and written through a BufferPool, changes reach the file when a page is
+// evicted or on Flush. Released pages are reused. Node methods have no way to
+// return an error, so the first I/O error is kept and returned by Err. After
+// it nothing more is written to the file.
+type PageFactory[K any, V any] struct {
+	file PageFile
+	size int
+	pages uint32
+	free uint32
+	root uint32
+	keys Codec[K]
+	values Codec[V]
+	compare func(a, b K) int
+	pool *BufferPool
+	decoded *MemNode[K, V]
+	page uint32
+	levels map[uint32] byte
+	err error
+}
+
+// A node stored in the page of a PageFactory
+type PageNode[K any, V any] struct {
+	factory *PageFactory[K, V]
+	page uint32
+}
+
+// Start a new page file in file with pages of size bytes
+func NewPageFactory[K any, V any](file PageFile, size int, compare func(a, b K) int, keys Codec[K], values Codec[V]) (*PageFactory[K, V], error) {
+	self := &PageFactory[K, V]{file: file, size: size, pages: 1, keys: keys, values: values, compare: compare, levels: map[uint32] byte {}}
+	if keys.Size() <= 0 || values.Size() <= 0 {
+		return nil, ErrCodec
+	}
+	if self.Order() < 2 {
+		return nil, ErrPageSize
+	}
+	self.pool = NewBufferPool(checked{file, size}, size, DefaultPoolSize)
+	self.header()
+	return self, self.err
+}
+
+// Open a page file that was started by NewPageFactory
+func OpenPageFactory[K any, V any](file PageFile, compare func(a, b K) int, keys Codec[K], values Codec[V]) (*PageFactory[K, V], error) {
+	if keys.Size() <= 0 || values.Size() <= 0 {
+		return nil, ErrCodec
+	}
+	buf := make ([] byte, 12)
+	if _, err := file.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+	if string(buf[0:4]) != pageMagic || binary.LittleEndian.Uint32(buf[4:]) != pageVersion {
+		return nil, ErrNotPageFile
+	}
+	self := &PageFactory[K, V]{file: file, keys: keys, values: values, compare: compare, levels: map[uint32] byte {}}
+	self.size = int(binary.LittleEndian.Uint32(buf[8:]))
+	if self.Order() < 2 {
+		return nil, &PageError{0, ErrCorruptPage}
+	}
+	self.pool = NewBufferPool(checked{file, self.size}, self.size, DefaultPoolSize)
+	buf, err := self.pool.Pin(0)
+	if err != nil {
+		return nil, err
+	}
+	self.pages = binary.LittleEndian.Uint32(buf[16:])
+	self.free = binary.LittleEndian.Uint32(buf[20:])
+	self.root = binary.LittleEndian.Uint32(buf[24:])
+	self.pool.Unpin(0, false)
+	return self, nil
+}
+
+// The largest order whose leaves and internal nodes fit in a page
+func (self *PageFactory[K, V]) Order() int {
+	leaf := (self.size - pageHeader) / (self.keys.Size() + self.values.Size())
+	node := (self.size - pageHeader - 12) / (self.keys.Size() + 12)
+	return min(leaf, node)
+}
+
+// The first error from reading or writing the file
+func (self *PageFactory[K, V]) Err() error {
+	return self.err
+}
+
+// The pool that holds the pages, to change its capacity or read its counts
+func (self *PageFactory[K, V]) Pool() *BufferPool {
+	return self.pool
+}
+
+// Write the changed pages to the file
+func (self *PageFactory[K, V]) Flush() error {
+	if self.err != nil {
+		return self.err
+	}
+	if err := self.pool.Flush(); err != nil {
+		self.fail(err)
+	}
+	return self.err
+}
+
+// Record root as the root of the tree, write the changed pages and sync the
+// file when it has a Sync method. When the file is a WAL a crash leaves it as
+// it was at this commit or the one before.
+func (self *PageFactory[K, V]) Commit(root Node[K, V]) error {
+	if self.err != nil {
+		return self.err
+	}
+	self.root = pageOf(root)
+	self.header()
+	if self.Flush() == nil {
+		self.fail(syncFile(self.file))
+	}
+	return self.err
+}
+
+// The root of the last commit, nil before the first
+func (self *PageFactory[K, V]) Root() Node[K, V] {
+	return self.node(self.root)
+}
+
+func (self *PageFactory[K, V]) NewNode(order int) Node[K, V] {
+	return self.allocate(order, pageNode)
+}
+
+func (self *PageFactory[K, V]) NewLeaf(order int) Node[K, V] {
+	return self.allocate(order, pageLeaf)
+}
+
+// Put the page of n on the free list
+func (self *PageFactory[K, V]) Release(n Node[K, V]) {
+	if self.err != nil {
+		return
+	}
+	page := n.(PageNode[K, V]).page
+	buf := self.fresh(page)
+	buf[0] = pageFree
+	binary.LittleEndian.PutUint32(buf[4:], self.free)
+	self.unpin(page, true)
+	delete(self.levels, page)
+	self.free = page
+	self.header()
+}
+
+// Take a page from the free list or the end of the file and write an empty
+// node of kind to it. After an error the node is past the end of the file
+// and never written.
+func (self *PageFactory[K, V]) allocate(order int, kind byte) Node[K, V] {
+	if order > self.Order() {
+		self.fail(ErrPageSize)
+	}
+	if self.err != nil {
+		return PageNode[K, V]{self, self.pages}
+	}
+	page := self.free
+	if page != 0 {
+		buf := self.pin(page)
+		if buf[0] != pageFree {
+			self.fail(&PageError{page, ErrCorruptPage})
+		}
+		next := binary.LittleEndian.Uint32(buf[4:])
+		self.unpin(page, false)
+		if self.err != nil {
+			return PageNode[K, V]{self, self.pages}
+		}
+		self.free = next
+	}else{
+		page = self.pages
+		self.pages++
+	}
+	self.header()
+	buf := self.fresh(page)
+	buf[0] = kind
+	if kind == pageNode {
+		buf[1] = 1
+	}
+	binary.LittleEndian.PutUint32(buf[16:], page)
+	self.unpin(page, true)
+	delete(self.levels, page)
+	return PageNode[K, V]{self, page}
+}
+
+func (self *PageFactory[K, V]) header() {
+	if self.err != nil {
+		return
+	}
+	buf := self.fresh(0)
+	copy(buf, pageMagic)
+	binary.LittleEndian.PutUint32(buf[4:], pageVersion)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(self.size))
+	binary.LittleEndian.PutUint32(buf[16:], self.pages)
+	binary.LittleEndian.PutUint32(buf[20:], self.free)
+	binary.LittleEndian.PutUint32(buf[24:], self.root)
+	self.unpin(0, true)
+}
+
+// Pin page in the pool, a failed pin gives a zeroed page that is not kept
+func (self *PageFactory[K, V]) pin(page uint32) [] byte {
+	buf, err := self.pool.pin(page, true)
+	if err != nil {
+		self.fail(err)
+		return make ([] byte, self.size)
+	}
+	return buf
+}
+
+// Pin page for a write of the whole page without reading it first
+func (self *PageFactory[K, V]) fresh(page uint32) [] byte {
+	if page == self.page {
+		self.decoded = nil
+	}
+	buf, err := self.pool.pin(page, false)
+	if err != nil {
+		self.fail(err)
+		return make ([] byte, self.size)
+	}
+	return buf
+}
+
+func (self *PageFactory[K, V]) unpin(page uint32, dirty bool) {
+	self.pool.Unpin(page, dirty)
+}
+
+func (self *PageFactory[K, V]) fail(err error) {
+	if self.err == nil && err != nil {
+		self.err = err
+		self.pool.freeze()
+	}
+}
+
+// The node for a page number, nil for page 0
+func (self *PageFactory[K, V]) node(page uint32) Node[K, V] {
+	if page == 0 {
+		return nil
+	}
+	return PageNode[K, V]{self, page}
+}
+
+// A node that can't be read is an empty leaf
+func (self PageNode[K, V]) isLeaf() bool {
+	return self.mem().Nodes == nil
+}
+
+func (self PageNode[K, V]) Find(key K) (int, bool) {
+	mem := self.mem()
+	pos, match := slices.BinarySearchFunc(mem.Entries, key, func(e Entry[K, V], key K) int {
+		return self.factory.compare(e.Key, key)
+	})
+	if match {
+		pos++
+	}
+	return pos, match
+}
+
+func (self PageNode[K, V]) Size() int {
+	return len(self.mem().Entries)
+}
+
+func (self PageNode[K, V]) Child(pos int) Node[K, V] {
+	return self.mem().Nodes[pos]
+}
+
+func (self PageNode[K, V]) Count(pos int) int {
+	return self.mem().Counts[pos]
+}
+
+func (self PageNode[K, V]) Value(pos int) V {
+	return self.mem().Entries[pos-1].Value
+}
+
+func (self PageNode[K, V]) Load(mem * MemNode[K, V]) {
+	f := self.factory
+	defer f.unpin(self.page, false)
+	buf := f.pin(self.page)
+	if ! checkNode(buf, self.page, f.levels, f.keys, f.values) {
+		f.fail(&PageError{self.page, ErrCorruptPage})
+		return
+	}
+	decodeNode(buf, mem, f.keys, f.values, f.node)
+	expectLevels(f.levels, mem, buf[1], pageOf[K, V])
+}
+
+func (self PageNode[K, V]) Store(mem *MemNode[K, V]) {
+	f := self.factory
+	if len(mem.Entries) > f.Order() {
+		f.fail(ErrPageSize)
+		return
+	}
+	level := byte(0)
+	if mem.Nodes != nil {
+		level = 1
+	}
+	if len(mem.Nodes) > 0 {
+		child := pageOf(mem.Nodes[0])
+		level = f.pin(child)[1] + 1
+		f.unpin(child, false)
+	}
+	if f.err != nil {
+		return
+	}
+	defer f.unpin(self.page, true)
+	encodeNode(f.fresh(self.page), mem, self.page, level, f.keys, f.values, pageOf[K, V])
+	f.levels[self.page] = level
+}
+
+func (self PageNode[K, V]) Dump(c chan Entry[K, V]) {
+	for _, e := range self.mem().Entries {
+		c <- e
+	}
+}
+
+func (self PageNode[K, V]) Next() Node[K, V] {
+	return self.mem().neighbor
+}
+
+func (self PageNode[K, V]) Prev() Node[K, V] {
+	return self.mem().previous
+}
+
+// The decoded node, a descent asks for several parts of a node in a row so
+// the factory keeps the last one it decoded until its page is written
+func (self PageNode[K, V]) mem() *MemNode[K, V] {
+	f := self.factory
+	if f.decoded == nil || f.page != self.page {
+		mem := new (MemNode[K, V])
+		self.Load(mem)
+		f.decoded, f.page = mem, self.page
+	}
+	return f.decoded
+}
+
+// The bytes mem takes in the layout of a node page
+func nodeSize[K any, V any](mem *MemNode[K, V], keys Codec[K], values Codec[V]) int {
+	if mem.Nodes == nil {
+		return pageHeader + len(mem.Entries) * (keys.Size() + values.Size())
+	}
+	return pageHeader + len(mem.Entries) * keys.Size() + len(mem.Nodes) * 12
+}
+
+// Write mem to buf in the layout of node page number, id numbers the linked
+// nodes
+func encodeNode[K any, V any](buf [] byte, mem *MemNode[K, V], number uint32, level byte, keys Codec[K], values Codec[V], id func(Node[K, V]) uint32) {
+	buf[1] = level
+	binary.LittleEndian.PutUint16(buf[2:], uint16(len(mem.Entries)))
+	binary.LittleEndian.PutUint32(buf[16:], number)
+	at := pageHeader
+	if mem.Nodes == nil {
+		buf[0] = pageLeaf
+		binary.LittleEndian.PutUint32(buf[4:], id(mem.neighbor))
+		binary.LittleEndian.PutUint32(buf[8:], id(mem.previous))
+		for _, e := range mem.Entries {
+			keys.Encode(buf[at:], e.Key)
+			at += keys.Size()
+			values.Encode(buf[at:], e.Value)
+			at += values.Size()
+		}
+		return
+	}
+	buf[0] = pageNode
+	for _, e := range mem.Entries {
+		keys.Encode(buf[at:], e.Key)
+		at += keys.Size()
+	}
+	for _, n := range mem.Nodes {
+		binary.LittleEndian.PutUint32(buf[at:], id(n))
+		at += 4
+	}
+	for _, c := range mem.Counts {
+		binary.LittleEndian.PutUint64(buf[at:], uint64(c))
+		at += 8
+	}
+}
+
+// Check that buf holds node number at a level that fits its kind and the
+// level its parent or neighbor put it at, with no more entries than fit in buf
+func checkNode[K any, V any](buf [] byte, number uint32, levels map[uint32] byte, keys Codec[K], values Codec[V]) bool {
+	if binary.LittleEndian.Uint32(buf[16:]) != number {
+		return false
+	}
+	if level, ok := levels[number]; ok && buf[1] != level {
+		return false
+	}
+	count := int(binary.LittleEndian.Uint16(buf[2:]))
+	switch buf[0] {
+	case pageLeaf:
+		return buf[1] == 0 && pageHeader + count * (keys.Size() + values.Size()) <= len(buf)
+	case pageNode:
+		return buf[1] > 0 && pageHeader + count * keys.Size() + (count + 1) * 12 <= len(buf)
+	}
+	return false
+}
+
+// Note the levels of the nodes mem links to, a leaf's neighbors are leaves and
+// a node's children are a level below it
+func expectLevels[K any, V any](levels map[uint32] byte, mem *MemNode[K, V], level byte, id func(Node[K, V]) uint32) {
+	if mem.Nodes == nil {
+		for _, n := range [] Node[K, V] {mem.neighbor, mem.previous} {
+			if n != nil {
+				levels[id(n)] = 0
+			}
+		}
+		return
+	}
+	for _, n := range mem.Nodes {
+		levels[id(n)] = level - 1
+	}
+}
+
+// Read mem from buf in the layout of a node page, node finds the linked nodes
+// by the numbers encodeNode stored
+func decodeNode[K any, V any](buf [] byte, mem *MemNode[K, V], keys Codec[K], values Codec[V], node func(uint32) Node[K, V]) {
+	count := int(binary.LittleEndian.Uint16(buf[2:]))
+	at := pageHeader
+	if buf[0] != pageNode {
+		for i := 0; i < count; i++ {
+			key := keys.Decode(buf[at:])
+			at += keys.Size()
+			mem.Entries = append(mem.Entries, Entry[K, V]{key, values.Decode(buf[at:])})
+			at += values.Size()
+		}
+		if neighbor := node(binary.LittleEndian.Uint32(buf[4:])); neighbor != nil {
+			mem.neighbor = neighbor
+		}
+		if previous := node(binary.LittleEndian.Uint32(buf[8:])); previous != nil {
+			mem.previous = previous
+		}
+		return
+	}
+	var none V
+	for i := 0; i < count; i++ {
+		mem.Entries = append(mem.Entries, Entry[K, V]{keys.Decode(buf[at:]), none})
+		at += keys.Size()
+	}
+	for i := 0; i <= count; i++ {
+		mem.Nodes = append(mem.Nodes, node(binary.LittleEndian.Uint32(buf[at:])))
+		at += 4
+	}
+	for i := 0; i <= count; i++ {
+		mem.Counts = append(mem.Counts, int(binary.LittleEndian.Uint64(buf[at:])))
+		at += 8
+	}
+}
+
+// A PageFile that puts a checksum in every page it writes and checks it in
+// every page it reads. Reads and writes are of whole pages.
+type checked struct {
+	PageFile
+	size int
+}
+
+func (self checked) ReadAt(p [] byte, off int64) (int, error) {
+	n, err := self.PageFile.ReadAt(p, off)
+	if err == nil && checksum(p) != binary.LittleEndian.Uint32(p[12:]) {
+		return n, &PageError{uint32(off / int64(self.size)), ErrCorruptPage}
+	}
+	return n, err
+}
+
+func (self checked) WriteAt(p [] byte, off int64) (int, error) {
+	binary.LittleEndian.PutUint32(p[12:], checksum(p))
+	return self.PageFile.WriteAt(p, off)
+}
+
+// The page number of a PageNode, 0 for nil
+func pageOf[K any, V any](n Node[K, V]) uint32 {
+	if n == nil {
+		return 0
+	}
+	return n.(PageNode[K, V]).page
+}
@@ -0,0 +1,245 @@
+package btree_test
+
+import (
+	"testing"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/cznic/b"
+	"lifealgorithmic.com/btree"
+	"lifealgorithmic.com/btree/btreetest"
+)
+
+type CznicAdapter struct {
+	tree *b.Tree	
+}
+
+func cznicCmp(a, b uint64) int {
+	if a < b {
+		return -1
+	}else if (a > b) {
+		return 1
+	}else{
+		return 0
+	}
+}
+
+func CznicTree() *CznicAdapter {
+	rval := new (CznicAdapter)
+	rval.tree = b.TreeNew(cznicCmp)
+	return rval
+}
+	
+func (self *CznicAdapter) Put(key uint64, value int) {
+	self.tree.Set(key, value)
+}
+
+func (self *CznicAdapter) Get(key uint64) int {
+	rval,okay := self.tree.Get(key) 
+	if okay {
+		return rval.(int)
+	}else{
+		return 0
+	}
+}
+
+func (self *CznicAdapter) GetOK(key uint64) (int, bool) {
+	rval,okay := self.tree.Get(key) 
+	if okay {
+		return rval.(int), true
+	}
+	return 0, false
+}
+
+func (self *CznicAdapter) Has(key uint64) bool {
+	_,okay := self.tree.Get(key) 
+	return okay
+}
+
+func (self *CznicAdapter) Swap(key uint64, value int) (int, bool) {
+	old, replaced := self.GetOK(key)
+	self.tree.Set(key, value)
+	return old, replaced
+}
+
+func (self *CznicAdapter) Delete(key uint64) (int, bool) {
+	old, found := self.GetOK(key)
+	self.tree.Delete(key)
+	return old, found
+}
+
+func (self *CznicAdapter) Len() int {
+	return self.tree.Len()
+}
+
+func (self *CznicAdapter) Iterate() chan btree.Entry[uint64, int] {
+	return nil
+}
+
+func (self *CznicAdapter) Validate() error {
+	return nil
+}
+
+func TestAutoRandomBTree(t *testing.T) {
+	order := 4
+	iterations := 2
+	insertions := 1000
+	tree := btree.NewBTree[uint64, int](order)
+	seed := time.Now().UnixNano()
+	btreetest.RandomTest(t, tree, seed, iterations, insertions)
+}
+
+func TestRandom(t *testing.T) {
+	orders := [] int {4, 8, 16, 32, 64, 128}
+	iterations := 10
+	insertions := 1000
+	
+	var tree * btree.BTree[uint64, int];
+	for _,order := range orders {
+		tree = btree.NewBTree[uint64, int](order)
+		runtime.GC()
+		seed := time.Now().UnixNano()
+		btreetest.RandomTest(t, tree, seed, iterations, insertions)
+	}
+}
+
+func TestAutoRandomBplus(t *testing.T) {
+	order := 4
+	iterations := 2
+	insertions := 100
+	tree := btree.NewBPlusTree[uint64, int](order)
+	seed := time.Now().UnixNano()
+	btreetest.RandomTest(t, tree, seed, iterations, insertions)
+}
+
+func TestRandomBplus(t *testing.T) {
+	orders := [] int {4, 8, 16, 32, 64, 128}
+	iterations := 10
+	insertions := 1000
+	
+	var tree * btree.BPlusTree[uint64, int];
+	for _,order := range orders {
+		tree = btree.NewBPlusTree[uint64, int](order)
+		runtime.GC()
+		seed := time.Now().UnixNano()
+		btreetest.RandomTest(t, tree, seed, iterations, insertions)
+	}
+}
+
+func TestRandomStrings(t *testing.T) {
+	gen := btreetest.Generator[string, string]{
+		Key: func(src rand.Source) string {
+			return strconv.FormatInt(src.Int63(), 36)
+		},
+		Value: strconv.Itoa,
+		Compare: strings.Compare,
+	}
+	for _,order := range [] int {4, 32} {
+		seed := time.Now().UnixNano()
+		btreetest.RandomTestWith(t, btree.NewBPlusTree[string, string](order), gen, seed, 4, 500)
+		btreetest.RandomTestWith(t, btree.NewBTree[string, string](order), gen, seed, 4, 500)
+	}
+}
+
+func TestRandomCznic(t *testing.T) {
+	iterations := 10
+	insertions := 1000
+	
+	tree := CznicTree()
+	seed := time.Now().UnixNano()
+	btreetest.RandomTest(t, tree, seed, iterations, insertions)
+}
+
+func BenchmarkRandomPut(b *testing.B) {
+	order := 128
+	tree := btree.NewBPlusTree[uint64, int](order)
+	_, src := btreetest.SetupBenchmark(b, tree)
+
+    b.ResetTimer()
+	for j:=0; j<b.N; j++ {
+		tree.Put(uint64(src.Int63()), j)
+	}	
+}
+
+func BenchmarkRandomGet(b *testing.B) {
+	order := 128
+	tree := btree.NewBPlusTree[uint64, int](order)
+	seed, src := btreetest.SetupBenchmark(b, tree)
+
+    b.ResetTimer()
+	src = rand.NewSource(seed)
+	for j:=0; j<b.N; j++ {
+		tree.Get(uint64(src.Int63()))
+	}
+}
+
+func BenchmarkRandomDelete(b *testing.B) {
+	order := 128
+	tree := btree.NewBPlusTree[uint64, int](order)
+	seed, src := btreetest.SetupBenchmark(b, tree)
+
+    b.ResetTimer()
+	src = rand.NewSource(seed)
+	for j:=0; j<b.N; j++ {
+		tree.Delete(uint64(src.Int63()))
+	}
+}
+
+/*
+func BenchmarkIteration(b *testing.B) {
+	order := 128
+	tree := btree.NewBPlusTree[uint64, int](order)
+	btreetest.SetupBenchmark(b, tree)
+
+    b.ResetTimer()
+	ch := tree.Iterate()
+	for _ = range (ch) {
+	}
+}
+*/
+
+func BenchmarkCznicRandomPut(b *testing.B) {
+	tree := CznicTree()
+	_, src := btreetest.SetupBenchmark(b, tree)
+
+    b.ResetTimer()
+	for j:=0; j<b.N; j++ {
+		tree.Put(uint64(src.Int63()), j)
+	}	
+}
+
+func BenchmarkCznicRandomGet(b *testing.B) {
+	tree := CznicTree()
+	seed, src := btreetest.SetupBenchmark(b, tree)
+
+    b.ResetTimer()
+	src = rand.NewSource(seed)
+	for j:=0; j<b.N; j++ {
+		tree.Get(uint64(src.Int63()))
+	}
+}
+
+func BenchmarkCznicRandomDelete(b *testing.B) {
+	tree := CznicTree()
+	seed, src := btreetest.SetupBenchmark(b, tree)
+
+    b.ResetTimer()
+	src = rand.NewSource(seed)
+	for j:=0; j<b.N; j++ {
+		tree.Delete(uint64(src.Int63()))
+	}
+}
+
+/*
+func BenchmarkCznicIteration(b *testing.B) {
+	tree := CznicTree()
+	_, src := btreetest.SetupBenchmark(b, tree)
+
+    b.ResetTimer()
+	ch := tree.Iterate()
+	for _ = range (ch) {
+	}
+}
+*/
\ No newline at end of file
@@ -0,0 +1,308 @@
+package btree
+
+import (
+	"context"
+	"iter"
+)
+
+const (
+	unbounded = iota
+	inclusive
+	exclusive
+)
+
+// A Bound is one end of a range of keys
+type Bound[K any] struct {
+	key K
+	kind int
+}
+
+// A bound that includes key
+func Inclusive[K any](key K) Bound[K] {
+	return Bound[K]{key, inclusive}
+}
+
+// A bound that excludes key
+func Exclusive[K any](key K) Bound[K] {
+	return Bound[K]{key, exclusive}
+}
+
+// A bound that does not limit the range
+func Unbounded[K any]() Bound[K] {
+	return Bound[K]{}
+}
+
+// True when key is not below the bound, used as the low end of a range
+func (self Bound[K]) above(compare func(a, b K) int, key K) bool {
+	switch self.kind {
+	case inclusive:
+		return compare(key, self.key) >= 0
+	case exclusive:
+		return compare(key, self.key) > 0
+	}
+	return true
+}
+
+// True when key is not above the bound, used as the high end of a range
+func (self Bound[K]) below(compare func(a, b K) int, key K) bool {
+	switch self.kind {
+	case inclusive:
+		return compare(key, self.key) <= 0
+	case exclusive:
+		return compare(key, self.key) < 0
+	}
+	return true
+}
+
+// Call fn in ascending order for the keys between lo and hi until fn returns false
+func (self *BPlusTree[K, V]) Range(lo, hi Bound[K], fn func(K, V) bool) {
+	c := self.Cursor()
+	ok := false
+	if lo.kind == unbounded {
+		ok = c.First()
+	}else{
+		ok = c.Seek(lo.key)
+		if ok && ! lo.above(self.compare, c.Key()) {
+			ok = c.Next()
+		}
+	}
+	for ; ok && hi.below(self.compare, c.Key()); ok = c.Next() {
+		if ! fn(c.Key(), c.Value()) {
+			return
+		}
+	}
+}
+
+// Call fn in descending order for the keys between lo and hi until fn returns false
+func (self *BPlusTree[K, V]) ReverseRange(lo, hi Bound[K], fn func(K, V) bool) {
+	c := self.Cursor()
+	ok := false
+	if hi.kind == unbounded {
+		ok = c.Last()
+	}else{
+		ok = c.before(hi.key, hi.kind == inclusive)
+	}
+	for ; ok && lo.above(self.compare, c.Key()); ok = c.Prev() {
+		if ! fn(c.Key(), c.Value()) {
+			return
+		}
+	}
+}
+
+// Call fn in ascending order for keys in [ge, lt)
+func (self *BPlusTree[K, V]) AscendRange(ge, lt K, fn func(K, V) bool) {
+	self.Range(Inclusive(ge), Exclusive(lt), fn)
+}
+
+// Call fn in descending order for keys in (gt, le]
+func (self *BPlusTree[K, V]) DescendRange(le, gt K, fn func(K, V) bool) {
+	self.ReverseRange(Exclusive(gt), Inclusive(le), fn)
+}
+
+// Call fn in ascending order for keys greater than or equal to ge
+func (self *BPlusTree[K, V]) AscendGreaterOrEqual(ge K, fn func(K, V) bool) {
+	self.Range(Inclusive(ge), Unbounded[K](), fn)
+}
+
+// Call fn in descending order for keys less than or equal to le
+func (self *BPlusTree[K, V]) DescendLessOrEqual(le K, fn func(K, V) bool) {
+	self.ReverseRange(Unbounded[K](), Inclusive(le), fn)
+}
+
+// Call fn in ascending order for the keys between lo and hi until fn returns false
+func (tree *BTree[K, V]) Range(lo, hi Bound[K], fn func(K, V) bool) {
+	var ascend func (n *bNode[K, V]) bool
+	ascend = func (n *bNode[K, V]) bool {
+		start := 0
+		if lo.kind != unbounded {
+			start = tree.nodeFind(n, lo.key)
+			if start > 0 && lo.kind == inclusive && tree.compare(n.Values[start-1].key, lo.key) == 0 {
+				start--
+			}
+		}
+		for i := start; i <= len(n.Values); i++ {
+			if n.Nodes != nil && ! ascend(n.Nodes[i]) {
+				return false
+			}
+			if i < len(n.Values) {
+				next := n.Values[i]
+				if ! hi.below(tree.compare, next.key) || ! fn(next.key, next.value) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	ascend(tree.root)
+}
+
+// Call fn in descending order for the keys between lo and hi until fn returns false
+func (tree *BTree[K, V]) ReverseRange(lo, hi Bound[K], fn func(K, V) bool) {
+	var descend func (n *bNode[K, V]) bool
+	descend = func (n *bNode[K, V]) bool {
+		end := len(n.Values)
+		if hi.kind != unbounded {
+			end = tree.nodeFind(n, hi.key)
+			if end > 0 && hi.kind == exclusive && tree.compare(n.Values[end-1].key, hi.key) == 0 {
+				end--
+			}
+		}
+		for i := end; i >= 0; i-- {
+			if n.Nodes != nil && ! descend(n.Nodes[i]) {
+				return false
+			}
+			if i > 0 {
+				next := n.Values[i-1]
+				if ! lo.above(tree.compare, next.key) || ! fn(next.key, next.value) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	descend(tree.root)
+}
+
+// Call fn in ascending order for keys in [ge, lt)
+func (tree *BTree[K, V]) AscendRange(ge, lt K, fn func(K, V) bool) {
+	tree.Range(Inclusive(ge), Exclusive(lt), fn)
+}
+
+// Call fn in descending order for keys in (gt, le]
+func (tree *BTree[K, V]) DescendRange(le, gt K, fn func(K, V) bool) {
+	tree.ReverseRange(Exclusive(gt), Inclusive(le), fn)
+}
+
+// Call fn in ascending order for keys greater than or equal to ge
+func (tree *BTree[K, V]) AscendGreaterOrEqual(ge K, fn func(K, V) bool) {
+	tree.Range(Inclusive(ge), Unbounded[K](), fn)
+}
+
+// Call fn in descending order for keys less than or equal to le
+func (tree *BTree[K, V]) DescendLessOrEqual(le K, fn func(K, V) bool) {
+	tree.ReverseRange(Unbounded[K](), Inclusive(le), fn)
+}
+
+// The entry with the smallest key
+func (self *BPlusTree[K, V]) Min() (K, V, bool) {
+	return first(self.Range, Unbounded[K](), Unbounded[K]())
+}
+
+// The entry with the largest key
+func (self *BPlusTree[K, V]) Max() (K, V, bool) {
+	return first(self.ReverseRange, Unbounded[K](), Unbounded[K]())
+}
+
+// The entry with the largest key less than or equal to key
+func (self *BPlusTree[K, V]) Floor(key K) (K, V, bool) {
+	return first(self.ReverseRange, Unbounded[K](), Inclusive(key))
+}
+
+// The entry with the smallest key greater than or equal to key
+func (self *BPlusTree[K, V]) Ceiling(key K) (K, V, bool) {
+	return first(self.Range, Inclusive(key), Unbounded[K]())
+}
+
+// The entry with the largest key less than key
+func (self *BPlusTree[K, V]) Lower(key K) (K, V, bool) {
+	return first(self.ReverseRange, Unbounded[K](), Exclusive(key))
+}
+
+// The entry with the smallest key greater than key
+func (self *BPlusTree[K, V]) Higher(key K) (K, V, bool) {
+	return first(self.Range, Exclusive(key), Unbounded[K]())
+}
+
+// All entries in ascending order, for use with range
+func (self *BPlusTree[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		self.Range(Unbounded[K](), Unbounded[K](), yield)
+	}
+}
+
+// All entries in descending order, for use with range
+func (self *BPlusTree[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		self.ReverseRange(Unbounded[K](), Unbounded[K](), yield)
+	}
+}
+
+// Stream all entries in ascending order. The channel is closed after the last
+// entry or when ctx is cancelled, which releases the producing goroutine.
+func (self *BPlusTree[K, V]) IterateContext(ctx context.Context) <-chan Entry[K, V] {
+	return stream(ctx, self.All())
+}
+
+// The entry with the smallest key
+func (tree *BTree[K, V]) Min() (K, V, bool) {
+	return first(tree.Range, Unbounded[K](), Unbounded[K]())
+}
+
+// The entry with the largest key
+func (tree *BTree[K, V]) Max() (K, V, bool) {
+	return first(tree.ReverseRange, Unbounded[K](), Unbounded[K]())
+}
+
+// The entry with the largest key less than or equal to key
+func (tree *BTree[K, V]) Floor(key K) (K, V, bool) {
+	return first(tree.ReverseRange, Unbounded[K](), Inclusive(key))
+}
+
+// The entry with the smallest key greater than or equal to key
+func (tree *BTree[K, V]) Ceiling(key K) (K, V, bool) {
+	return first(tree.Range, Inclusive(key), Unbounded[K]())
+}
+
+// The entry with the largest key less than key
+func (tree *BTree[K, V]) Lower(key K) (K, V, bool) {
+	return first(tree.ReverseRange, Unbounded[K](), Exclusive(key))
+}
+
+// The entry with the smallest key greater than key
+func (tree *BTree[K, V]) Higher(key K) (K, V, bool) {
+	return first(tree.Range, Exclusive(key), Unbounded[K]())
+}
+
+// All entries in ascending order, for use with range
+func (tree *BTree[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		tree.Range(Unbounded[K](), Unbounded[K](), yield)
+	}
+}
+
+// All entries in descending order, for use with range
+func (tree *BTree[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		tree.ReverseRange(Unbounded[K](), Unbounded[K](), yield)
+	}
+}
+
+// Stream all entries in ascending order. The channel is closed after the last
+// entry or when ctx is cancelled, which releases the producing goroutine.
+func (tree *BTree[K, V]) IterateContext(ctx context.Context) <-chan Entry[K, V] {
+	return stream(ctx, tree.All())
+}
+
+// The first entry produced by a range scan
+func first[K any, V any](scan func(lo, hi Bound[K], fn func(K, V) bool), lo, hi Bound[K]) (key K, value V, ok bool) {
+	scan(lo, hi, func(k K, v V) bool {
+		key, value, ok = k, v, true
+		return false
+	})
+	return
+}
+
+func stream[K any, V any](ctx context.Context, seq iter.Seq2[K, V]) <-chan Entry[K, V] {
+	ch := make (chan Entry[K, V])
+	go func() {
+		defer close(ch)
+		for k, v := range seq {
+			select {
+			case ch <- Entry[K, V]{k, v}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
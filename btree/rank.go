@@ -0,0 +1,84 @@
+package btree
+
+// The number of keys less than key, which is the position key has (or would
+// have) in the sorted order of the tree
+func (self *BPlusTree[K, V]) Rank(key K) int {
+	return self.rank(key, false)
+}
+
+// The i-th smallest entry, counting from zero
+func (self *BPlusTree[K, V]) Select(i int) (K, V, bool) {
+	var key K
+	var value V
+	if i < 0 || i >= self.size || self.Err() != nil {
+		return key, value, false
+	}
+	// A node that can't be read leaves the counts short of i
+	m := self.load(self.root)
+	for m.Nodes != nil && self.Err() == nil {
+		pos := 0
+		for pos < len(m.Counts) && i >= m.Counts[pos] {
+			i -= m.Counts[pos]
+			pos++
+		}
+		if pos == len(m.Nodes) {
+			return key, value, false
+		}
+		m = self.load(m.Nodes[pos])
+	}
+	if self.Err() != nil || i >= len(m.Entries) {
+		return key, value, false
+	}
+	return m.Entries[i].Key, m.Entries[i].Value, true
+}
+
+// The number of keys between lo and hi
+func (self *BPlusTree[K, V]) CountRange(lo, hi Bound[K]) int {
+	if self.Err() != nil {
+		return 0
+	}
+	var first, last int
+	switch lo.kind {
+	case inclusive:
+		first = self.rank(lo.key, false)
+	case exclusive:
+		first = self.rank(lo.key, true)
+	}
+	switch hi.kind {
+	case inclusive:
+		last = self.rank(hi.key, true)
+	case exclusive:
+		last = self.rank(hi.key, false)
+	default:
+		last = self.size
+	}
+	if last < first {
+		return 0
+	}
+	return last - first
+}
+
+// The number of keys less than key (or equal to key when inclusive), 0 when
+// a node can't be read
+func (self *BPlusTree[K, V]) rank(key K, inclusive bool) int {
+	count := 0
+	m := self.load(self.root)
+	for m.Nodes != nil && self.Err() == nil {
+		pos, _ := self.find(m, key)
+		if pos >= len(m.Nodes) || pos > len(m.Counts) {
+			return 0
+		}
+		for _, c := range m.Counts[:pos] {
+			count += c
+		}
+		m = self.load(m.Nodes[pos])
+	}
+	if self.Err() != nil {
+		return 0
+	}
+	pos, match := self.find(m, key)
+	if match && ! inclusive {
+		pos--
+	}
+	return count + pos
+}
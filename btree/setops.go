@@ -0,0 +1,83 @@
+package btree
+
+// A tree with the keys of both trees. merge combines the values of a key that
+// is in both, when merge is nil the value from this tree is kept.
+func (self *BPlusTree[K, V]) Union(other *BPlusTree[K, V], merge func(key K, mine, theirs V) V) (*BPlusTree[K, V], error) {
+	return self.combine(other, func(mine, theirs bool) bool {
+		return true
+	}, merge)
+}
+
+// A tree with the keys that are in both trees, with values combined by merge
+// (or taken from this tree when merge is nil)
+func (self *BPlusTree[K, V]) Intersect(other *BPlusTree[K, V], merge func(key K, mine, theirs V) V) (*BPlusTree[K, V], error) {
+	return self.combine(other, func(mine, theirs bool) bool {
+		return mine && theirs
+	}, merge)
+}
+
+// A tree with the entries of this tree whose keys are not in other
+func (self *BPlusTree[K, V]) Difference(other *BPlusTree[K, V]) (*BPlusTree[K, V], error) {
+	return self.combine(other, func(mine, theirs bool) bool {
+		return mine && ! theirs
+	}, nil)
+}
+
+// A tree with the entries whose keys are in exactly one of the trees
+func (self *BPlusTree[K, V]) SymmetricDifference(other *BPlusTree[K, V]) (*BPlusTree[K, V], error) {
+	return self.combine(other, func(mine, theirs bool) bool {
+		return mine != theirs
+	}, nil)
+}
+
+// Walk the leaves of both trees in step and bulk load the entries that keep
+// accepts into a new tree. other must be ordered the same way as this tree,
+// otherwise the keys come out of order and the error from BulkLoad is returned.
+func (self *BPlusTree[K, V]) combine(other *BPlusTree[K, V], keep func(mine, theirs bool) bool, merge func(K, V, V) V) (*BPlusTree[K, V], error) {
+	seq := func(yield func(K, V) bool) {
+		a, b := self.Cursor(), other.Cursor()
+		moreA, moreB := a.First(), b.First()
+		for moreA || moreB {
+			if (! moreA && ! keep(false, true)) || (! moreB && ! keep(true, false)) {
+				return
+			}
+			order := 0
+			switch {
+			case ! moreB:
+				order = -1
+			case ! moreA:
+				order = 1
+			default:
+				order = self.compare(a.Key(), b.Key())
+			}
+			switch {
+			case order < 0:
+				if keep(true, false) && ! yield(a.Key(), a.Value()) {
+					return
+				}
+				moreA = a.Next()
+			case order > 0:
+				if keep(false, true) && ! yield(b.Key(), b.Value()) {
+					return
+				}
+				moreB = b.Next()
+			default:
+				if keep(true, true) {
+					value := a.Value()
+					if merge != nil {
+						value = merge(a.Key(), value, b.Value())
+					}
+					if ! yield(a.Key(), value) {
+						return
+					}
+				}
+				moreA, moreB = a.Next(), b.Next()
+			}
+		}
+	}
+	tree := self.empty()
+	if err := tree.BulkLoad(seq, 1); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
@@ -4,104 +4,138 @@ import (
 //	"fmt"
 )
 
-type SimpleNode struct {
-	keys [] uint64
-	nodes [] * SimpleNode
-	values [] int
-	neighbor * SimpleNode
+type SimpleNode[K any, V any] struct {
+	keys [] K
+	nodes [] * SimpleNode[K, V]
+	counts [] int
+	values [] V
+	neighbor * SimpleNode[K, V]
+	previous * SimpleNode[K, V]
+	compare func(a, b K) int
 }
 
-type SimpleFactory struct {
+type SimpleFactory[K any, V any] struct {
+	compare func(a, b K) int
 }
 
-func (self *SimpleFactory) NewNode(order int) Node {
-	n := new (SimpleNode)
-	n.keys = make ([] uint64, 0, order)
-	n.nodes = make ([] *SimpleNode, 0, order)
+func NewSimpleFactory[K any, V any](compare func(a, b K) int) *SimpleFactory[K, V] {
+	return &SimpleFactory[K, V]{compare}
+}
+
+func (self *SimpleFactory[K, V]) NewNode(order int) Node[K, V] {
+	n := new (SimpleNode[K, V])
+	n.keys = make ([] K, 0, order)
+	n.nodes = make ([] *SimpleNode[K, V], 0, order)
+	n.counts = make ([] int, 0, order)
 	n.values = nil
+	n.compare = self.compare
 	return n
 }
 
-func (self *SimpleFactory) NewLeaf(order int) Node {
-	n := new (SimpleNode)
-	n.keys = make ([] uint64, 0, order)
-	n.values = make ([] int, 0, order)
+func (self *SimpleFactory[K, V]) NewLeaf(order int) Node[K, V] {
+	n := new (SimpleNode[K, V])
+	n.keys = make ([] K, 0, order)
+	n.values = make ([] V, 0, order)
 	n.nodes = nil
+	n.compare = self.compare
 	return n
 }
 
-func (self *SimpleFactory) Release(n Node) {
+func (self *SimpleFactory[K, V]) Release(n Node[K, V]) {
 }
 
-func (self *SimpleNode) isLeaf() bool {
+func (self *SimpleNode[K, V]) isLeaf() bool {
 	return (self.nodes == nil)
 }
 
-func (self *SimpleNode) Find(item interface{}) (int, bool, interface{}) { 
-	key := item.(uint64)
+func (self *SimpleNode[K, V]) Find(key K) (int, bool) { 
 	pos := len(self.keys)
 	for i, k := range self.keys {
-		if key < k {
+		if self.compare(key, k) < 0 {
 			pos = i
 			break
 		}
 	}
-	match := (pos > 0 && key == self.keys[pos-1])
-	if self.nodes != nil {
-		return pos, match, self.nodes[pos]
-	}else{
-		if match {
-			return pos, match, self.values[pos-1]
-		}else{
-			return pos, match, nil
-		}
-	}
+	match := (pos > 0 && self.compare(key, self.keys[pos-1]) == 0)
+	return pos, match
+}
+
+func (self *SimpleNode[K, V]) Size() int {
+	return len(self.keys)
+}
+
+func (self *SimpleNode[K, V]) Child(pos int) Node[K, V] {
+	return self.nodes[pos]
 }
 
-func (self *SimpleNode) Load(mem * MemNode) {
+func (self *SimpleNode[K, V]) Count(pos int) int {
+	return self.counts[pos]
+}
+
+func (self *SimpleNode[K, V]) Value(pos int) V {
+	return self.values[pos-1]
+}
+
+func (self *SimpleNode[K, V]) Load(mem * MemNode[K, V]) {
+	var none V
 	if self.nodes != nil {
 		for i, k := range self.keys {
-			mem.Entries = append(mem.Entries, Entry{k, nil})
+			mem.Entries = append(mem.Entries, Entry[K, V]{k, none})
 			mem.Nodes = append(mem.Nodes, self.nodes[i])
 		}
 		mem.Nodes = append(mem.Nodes, self.nodes[len(self.keys)])
+		mem.Counts = append(mem.Counts, self.counts...)
 	}else{
 		for i, k := range self.keys {
-			mem.Entries = append(mem.Entries, Entry{k, self.values[i]})
+			mem.Entries = append(mem.Entries, Entry[K, V]{k, self.values[i]})
+		}
+		if self.neighbor != nil {
+			mem.neighbor = self.neighbor
+		}
+		if self.previous != nil {
+			mem.previous = self.previous
 		}
-		mem.neighbor = self.neighbor
 	}
 }
 
-func (self *SimpleNode) Store(mem *MemNode) {
+func (self *SimpleNode[K, V]) Store(mem *MemNode[K, V]) {
 	self.keys = nil
 	if self.nodes != nil {
 		self.nodes = self.nodes[:0]
 		for _, k := range mem.Entries {
-			self.keys = append(self.keys, k.Key.(uint64))
+			self.keys = append(self.keys, k.Key)
 		}
 		for _, n := range mem.Nodes {
-			self.nodes = append(self.nodes, n.(*SimpleNode))
+			self.nodes = append(self.nodes, n.(*SimpleNode[K, V]))
 		}
+		self.counts = append(self.counts[:0], mem.Counts...)
 	}else{
 		self.values = self.values[:0]
 		for _, k := range mem.Entries {
-			self.keys = append(self.keys, k.Key.(uint64))
-			self.values = append(self.values, k.Value.(int))
+			self.keys = append(self.keys, k.Key)
+			self.values = append(self.values, k.Value)
 		}
-		self.neighbor = mem.neighbor.(*SimpleNode)
+		self.neighbor, _ = mem.neighbor.(*SimpleNode[K, V])
+		self.previous, _ = mem.previous.(*SimpleNode[K, V])
 	}
 }
 
-func (self *SimpleNode) Dump(c chan Entry) {
+func (self *SimpleNode[K, V]) Dump(c chan Entry[K, V]) {
 	for i, k := range self.keys {
-		c <- Entry{k, self.values[i]}
+		c <- Entry[K, V]{k, self.values[i]}
 	}
 }
 
-func (self *SimpleNode) Next() Node {
+func (self *SimpleNode[K, V]) Next() Node[K, V] {
 	if self.neighbor == nil {
 		return nil
 	}
 	return self.neighbor
 }
+
+func (self *SimpleNode[K, V]) Prev() Node[K, V] {
+	if self.previous == nil {
+		return nil
+	}
+	return self.previous
+}
@@ -0,0 +1,439 @@
+package btree
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+)
+
+var (
+	ErrOverlap = errors.New("btree: joined trees have overlapping keys")
+	ErrOrder = errors.New("btree: joined trees have different orders")
+	ErrFactory = errors.New("btree: joined trees have different node factories")
+)
+
+// A subtree cut out of a tree, height counts the levels above the leaves.
+// An empty trunk has a nil root.
+type trunk[K any, V any] struct {
+	root Node[K, V]
+	height int
+}
+
+// Remove the keys between lo and hi and return how many were removed. The
+// tree is cut at both ends of the range, the subtrees in between are released
+// without loading their leaves and the two outer parts are joined back together.
+func (self *BPlusTree[K, V]) DeleteRange(lo, hi Bound[K]) int {
+	count := self.CountRange(lo, hi)
+	if count == 0 || self.Err() != nil {
+		return 0
+	}
+	whole := trunk[K, V]{self.root, self.height()}
+	below, rest := trunk[K, V]{}, whole
+	if lo.kind != unbounded {
+		below, rest = self.cut(whole, lo.key, func(key K) bool {
+			return lo.above(self.compare, key)
+		})
+	}
+	middle, above := rest, trunk[K, V]{}
+	if hi.kind != unbounded {
+		middle, above = self.cut(rest, hi.key, func(key K) bool {
+			return ! hi.below(self.compare, key)
+		})
+	}
+	self.discard(middle.root, middle.height)
+	self.size -= count
+
+	// Stitch the leaf chain back together and join the parts
+	if below.root != nil && above.root != nil {
+		left, right := self.load(self.rightmost(below.root)), self.load(self.leftmost(above.root))
+		left.neighbor = right.ref
+		right.previous = left.ref
+		self.store(left)
+		self.store(right)
+		below = self.join(below, Entry[K, V]{Key: right.Entries[0].Key}, above)
+	}else if below.root == nil {
+		below = above
+	}
+	if below.root == nil {
+		below.root = self.factory.NewLeaf(self.N)
+	}
+	self.root = below.root
+	self.ends()
+	return count
+}
+
+// Cut the tree in two at key, the first tree holds the keys less than key and
+// the second holds the rest. The nodes move to the new trees, which share the
+// node factory, and this tree is left empty. When Err is set the tree is not
+// cut and both new trees are empty.
+func (self *BPlusTree[K, V]) SplitAt(key K) (*BPlusTree[K, V], *BPlusTree[K, V]) {
+	if self.Err() != nil || self.size == 0 {
+		return self.empty(), self.empty()
+	}
+	count := self.Rank(key)
+	lt, rt := self.cut(trunk[K, V]{self.root, self.height()}, key, func(k K) bool {
+		return self.compare(k, key) >= 0
+	})
+	left, right := self.empty(), self.empty()
+	left.graft(lt, count)
+	right.graft(rt, self.size - count)
+	self.reset()
+	return left, right
+}
+
+// Concatenate two trees where every key in a is less than every key in b.
+// The nodes move to the new tree and a and b are left empty. Both trees
+// must have the same order and node factory, the factory orders the keys in
+// the nodes so trees of different factories may not agree on the order.
+func Join[K any, V any](a, b *BPlusTree[K, V]) (*BPlusTree[K, V], error) {
+	if a.N != b.N {
+		return nil, ErrOrder
+	}
+	if a.factory != b.factory {
+		return nil, ErrFactory
+	}
+	if err := cmp.Or(a.Err(), b.Err()); err != nil {
+		return nil, err
+	}
+	first, _, _ := b.Min()
+	if last, _, ok := a.Max(); ok && b.size > 0 && a.compare(last, first) >= 0 {
+		return nil, ErrOverlap
+	}
+	tree := a.empty()
+	switch {
+	case b.size == 0:
+		tree.graft(trunk[K, V]{a.root, a.height()}, a.size)
+		tree.release(b.root)
+	case a.size == 0:
+		tree.graft(trunk[K, V]{b.root, b.height()}, b.size)
+		tree.release(a.root)
+	default:
+		left, right := tree.load(a.tail), tree.load(b.head)
+		left.neighbor = right.ref
+		right.previous = left.ref
+		tree.store(left)
+		tree.store(right)
+		t := tree.join(trunk[K, V]{a.root, a.height()}, Entry[K, V]{Key: first}, trunk[K, V]{b.root, b.height()})
+		tree.graft(t, a.size + b.size)
+	}
+	a.reset()
+	b.reset()
+	return tree, nil
+}
+
+// A new empty tree with the same order, ordering and node factory
+func (self *BPlusTree[K, V]) empty() *BPlusTree[K, V] {
+	tree := &BPlusTree[K, V]{N: self.N, factory: self.factory, compare: self.compare}
+	tree.reset()
+	return tree
+}
+
+// Start over with an empty root, the old nodes are left to their new owner.
+// The root is a bare leaf until the tree is written to, so the trees emptied
+// by SplitAt and Join don't hold on to a node of the factory.
+func (self *BPlusTree[K, V]) reset() {
+	self.root = bare[K, V]{}
+	self.head = self.root
+	self.tail = self.root
+	self.size = 0
+}
+
+// Give a tree with a bare root a leaf of its factory before it is written to
+func (self *BPlusTree[K, V]) plant() {
+	if _, ok := self.root.(bare[K, V]); ok {
+		self.root = self.factory.NewLeaf(self.N)
+		self.head = self.root
+		self.tail = self.root
+	}
+}
+
+// Release a node of the factory, a bare root belongs to none
+func (self *BPlusTree[K, V]) release(n Node[K, V]) {
+	if _, ok := n.(bare[K, V]); ! ok {
+		self.factory.Release(n)
+	}
+}
+
+// Take t as the contents of an empty tree
+func (self *BPlusTree[K, V]) graft(t trunk[K, V], size int) {
+	if t.root == nil {
+		return
+	}
+	self.release(self.root)
+	self.root = t.root
+	self.size = size
+	self.ends()
+}
+
+// The empty leaf at the root of an emptied tree. It can be read like any
+// leaf but is replaced by plant before anything is stored.
+type bare[K any, V any] struct {
+}
+
+func (self bare[K, V]) isLeaf() bool {
+	return true
+}
+
+func (self bare[K, V]) Find(key K) (int, bool) {
+	return 0, false
+}
+
+func (self bare[K, V]) Size() int {
+	return 0
+}
+
+func (self bare[K, V]) Child(pos int) Node[K, V] {
+	return nil
+}
+
+func (self bare[K, V]) Count(pos int) int {
+	return 0
+}
+
+func (self bare[K, V]) Value(pos int) (value V) {
+	return
+}
+
+func (self bare[K, V]) Load(mem *MemNode[K, V]) {
+}
+
+func (self bare[K, V]) Store(mem *MemNode[K, V]) {
+}
+
+func (self bare[K, V]) Dump(c chan Entry[K, V]) {
+}
+
+func (self bare[K, V]) Next() Node[K, V] {
+	return nil
+}
+
+func (self bare[K, V]) Prev() Node[K, V] {
+	return nil
+}
+
+// Cut t in two along the path toward key. The entries for which right is true
+// go to the right part, right must be false for every key before the first key
+// it is true for. Either part may be empty, the leaf chain is broken between
+// the parts.
+func (self *BPlusTree[K, V]) cut(t trunk[K, V], key K, right func(K) bool) (lt, rt trunk[K, V]) {
+	type piece struct {
+		trunk[K, V]
+		sep Entry[K, V]
+	}
+	var lefts, rights [] piece
+
+	// Peel off the links either side of the path
+	n, h := t.root, t.height
+	for ! n.isLeaf() {
+		me := self.load(n)
+		pos, _ := self.find(me, key)
+		kept := false
+		switch pos {
+		case 0:
+		case 1:
+			lefts = append(lefts, piece{trunk[K, V]{me.Nodes[0], h - 1}, me.Entries[0]})
+		default:
+			sep := me.Entries[pos-1]
+			m := &MemNode[K, V]{ref: me.ref, Entries: me.Entries[:pos-1], Nodes: me.Nodes[:pos], Counts: me.Counts[:pos]}
+			self.store(m)
+			kept = true
+			lefts = append(lefts, piece{trunk[K, V]{m.ref, h}, sep})
+		}
+		switch len(me.Nodes) - pos - 1 {
+		case 0:
+		case 1:
+			rights = append(rights, piece{trunk[K, V]{me.Nodes[pos+1], h - 1}, me.Entries[pos]})
+		default:
+			m := self.blank(self.factory.NewNode(self.N))
+			m.Entries = append(m.Entries, me.Entries[pos+1:]...)
+			m.Nodes = append(m.Nodes, me.Nodes[pos+1:]...)
+			m.Counts = append(m.Counts, me.Counts[pos+1:]...)
+			self.store(m)
+			rights = append(rights, piece{trunk[K, V]{m.ref, h}, me.Entries[pos]})
+		}
+		if ! kept {
+			self.factory.Release(me.ref)
+		}
+		n = me.Nodes[pos]
+		h--
+	}
+
+	// Split the leaf, the left half keeps the node
+	me := self.load(n)
+	at := slices.IndexFunc(me.Entries, func(e Entry[K, V]) bool {
+		return right(e.Key)
+	})
+	if at < 0 {
+		at = len(me.Entries)
+	}
+	if at < len(me.Entries) {
+		m := self.blank(self.factory.NewLeaf(self.N))
+		m.Entries = append(m.Entries, me.Entries[at:]...)
+		m.neighbor = me.neighbor
+		self.store(m)
+		self.relink(m)
+		rt = trunk[K, V]{m.ref, 0}
+	}else if me.neighbor != nil {
+		next := self.load(me.neighbor)
+		next.previous = nil
+		self.store(next)
+	}
+	if at > 0 {
+		me.Entries = me.Entries[:at]
+		me.neighbor = nil
+		self.store(me)
+		lt = trunk[K, V]{me.ref, 0}
+	}else{
+		if me.previous != nil {
+			prev := self.load(me.previous)
+			prev.neighbor = nil
+			self.store(prev)
+		}
+		self.factory.Release(me.ref)
+	}
+
+	// Join the pieces back up from the smallest
+	for i := len(lefts) - 1; i >= 0; i-- {
+		lt = self.join(lefts[i].trunk, lefts[i].sep, lt)
+	}
+	for i := len(rights) - 1; i >= 0; i-- {
+		rt = self.join(rt, rights[i].sep, rights[i].trunk)
+	}
+	return
+}
+
+// Join two trunks where every key in l is less than sep and every key in r is
+// at least sep. The last leaf of l must already be linked to the first leaf of
+// r. The shorter trunk is hung off the spine of the taller one and overfull
+// nodes are split on the way back up.
+func (self *BPlusTree[K, V]) join(l trunk[K, V], sep Entry[K, V], r trunk[K, V]) trunk[K, V] {
+	if l.root == nil {
+		return r
+	}
+	if r.root == nil {
+		return l
+	}
+	if l.height == r.height {
+		lm, rm := self.load(l.root), self.load(r.root)
+		m := self.blank(self.factory.NewNode(self.N))
+		m.Entries = append(m.Entries, sep)
+		m.Nodes = append(m.Nodes, l.root, r.root)
+		m.Counts = append(m.Counts, self.total(lm), self.total(rm))
+		if len(lm.Entries) < self.N/2 {
+			self.pivot(0, lm, m)
+		}else if len(rm.Entries) < self.N/2 {
+			self.pivot(1, rm, m)
+		}
+		if len(m.Nodes) == 1 {
+			self.factory.Release(m.ref)
+			return trunk[K, V]{m.Nodes[0], l.height}
+		}
+		self.store(m)
+		return trunk[K, V]{m.ref, l.height + 1}
+	}
+
+	tall, short, onright := l, r, true
+	if l.height < r.height {
+		tall, short, onright = r, l, false
+	}
+	edge := func(m *MemNode[K, V]) int {
+		if onright {
+			return len(m.Nodes) - 1
+		}
+		return 0
+	}
+	sm := self.load(short.root)
+	var path [] *MemNode[K, V]
+	n := tall.root
+	for h := tall.height; h > short.height; h-- {
+		m := self.load(n)
+		path = append(path, m)
+		n = m.Nodes[edge(m)]
+	}
+	m := path[len(path)-1]
+	if onright {
+		m.Entries = append(m.Entries, sep)
+		m.Nodes = append(m.Nodes, short.root)
+		m.Counts = append(m.Counts, self.total(sm))
+	}else{
+		m.Entries = slices.Insert(m.Entries, 0, sep)
+		m.Nodes = slices.Insert(m.Nodes, 0, short.root)
+		m.Counts = slices.Insert(m.Counts, 0, self.total(sm))
+	}
+	if len(sm.Entries) < self.N/2 {
+		self.pivot(edge(m), sm, m)
+	}
+
+	// Carry splits up the spine
+	var rnode *MemNode[K, V]
+	var median *Entry[K, V]
+	for i := len(path) - 1; i >= 0; i-- {
+		m := path[i]
+		if i < len(path) - 1 {
+			pos := edge(m)
+			m.Counts[pos] = self.total(path[i+1])
+			if rnode != nil {
+				m.Counts[pos] += self.total(rnode)
+				self.insert(pos, m, median, rnode)
+			}
+		}
+		rnode, median = nil, nil
+		if len(m.Entries) > self.N {
+			rnode, median = self.split(m)
+		}
+		self.store(m)
+	}
+	if rnode == nil {
+		return trunk[K, V]{path[0].ref, tall.height}
+	}
+	root := self.blank(self.factory.NewNode(self.N))
+	root.Entries = append(root.Entries, *median)
+	root.Nodes = append(root.Nodes, path[0].ref, rnode.ref)
+	root.Counts = append(root.Counts, self.total(path[0]), self.total(rnode))
+	self.store(root)
+	return trunk[K, V]{root.ref, tall.height + 1}
+}
+
+// Find the first and last leaves again after the tree was taken apart
+func (self *BPlusTree[K, V]) ends() {
+	self.head = self.leftmost(self.root)
+	self.tail = self.rightmost(self.root)
+}
+
+// The first leaf under n
+func (self *BPlusTree[K, V]) leftmost(n Node[K, V]) Node[K, V] {
+	for ! n.isLeaf() {
+		n = n.Child(0)
+	}
+	return n
+}
+
+// The last leaf under n
+func (self *BPlusTree[K, V]) rightmost(n Node[K, V]) Node[K, V] {
+	for ! n.isLeaf() {
+		n = n.Child(n.Size())
+	}
+	return n
+}
+
+// The number of levels above the leaves
+func (self *BPlusTree[K, V]) height() (h int) {
+	for n := self.root; ! n.isLeaf(); n = n.Child(0) {
+		h++
+	}
+	return
+}
+
+// Release n and every node under it, n is height levels above the leaves.
+// Leaves are released without being loaded.
+func (self *BPlusTree[K, V]) discard(n Node[K, V], height int) {
+	if n == nil {
+		return
+	}
+	if height > 0 {
+		for _, child := range self.load(n).Nodes {
+			self.discard(child, height - 1)
+		}
+	}
+	self.factory.Release(n)
+}
@@ -0,0 +1,161 @@
+package btree
+
+import (
+	"fmt"
+)
+
+// Check the structure of the tree: keys are ordered within nodes and against
+// the separators above them, nodes other than the root are at least half full
+// and at most full, leaves are all at the same depth, subtree counts and the
+// size add up and the leaf chain links every leaf in order in both directions.
+// Returns an error describing the first problem found.
+func (self *BPlusTree[K, V]) Validate() error {
+	depth := -1
+	var last *MemNode[K, V]
+	var check func (n Node[K, V], level int, lo, hi *K) (int, error)
+	check = func(n Node[K, V], level int, lo, hi *K) (int, error) {
+		m := self.load(n)
+		if n != self.root && len(m.Entries) < self.N/2 {
+			return 0, fmt.Errorf("btree: node at depth %d has %d keys, less than %d", level, len(m.Entries), self.N/2)
+		}
+		if len(m.Entries) > self.N {
+			return 0, fmt.Errorf("btree: node at depth %d has %d keys, more than %d", level, len(m.Entries), self.N)
+		}
+		for i, e := range m.Entries {
+			if i > 0 && self.compare(m.Entries[i-1].Key, e.Key) >= 0 {
+				return 0, fmt.Errorf("btree: keys out of order at depth %d: %v, %v", level, m.Entries[i-1].Key, e.Key)
+			}
+			if (lo != nil && self.compare(e.Key, *lo) < 0) || (hi != nil && self.compare(e.Key, *hi) >= 0) {
+				return 0, fmt.Errorf("btree: key %v at depth %d is outside of its separators", e.Key, level)
+			}
+		}
+
+		if n.isLeaf() {
+			if depth < 0 {
+				depth = level
+			}else if depth != level {
+				return 0, fmt.Errorf("btree: leaves at depth %d and %d", depth, level)
+			}
+			if last == nil {
+				if n != self.head || m.previous != nil {
+					return 0, fmt.Errorf("btree: the first leaf is not the head of the chain")
+				}
+			}else if last.neighbor != n || m.previous != last.ref {
+				return 0, fmt.Errorf("btree: leaf chain is broken before key %v", m.Entries[0].Key)
+			}
+			last = m
+			return len(m.Entries), nil
+		}
+
+		if len(m.Nodes) != len(m.Entries) + 1 || len(m.Counts) != len(m.Nodes) {
+			return 0, fmt.Errorf("btree: node at depth %d has %d keys, %d links and %d counts", level, len(m.Entries), len(m.Nodes), len(m.Counts))
+		}
+		if n == self.root && len(m.Nodes) < 2 {
+			return 0, fmt.Errorf("btree: root has a single link")
+		}
+		total := 0
+		for i, c := range m.Nodes {
+			clo, chi := lo, hi
+			if i > 0 {
+				clo = &m.Entries[i-1].Key
+			}
+			if i < len(m.Entries) {
+				chi = &m.Entries[i].Key
+			}
+			count, err := check(c, level + 1, clo, chi)
+			if err != nil {
+				return 0, err
+			}
+			if count != m.Counts[i] {
+				return 0, fmt.Errorf("btree: link %d at depth %d counts %d entries, found %d", i, level, m.Counts[i], count)
+			}
+			total += count
+		}
+		return total, nil
+	}
+
+	size, err := check(self.root, 0, nil, nil)
+	if err != nil {
+		return err
+	}
+	if size != self.size {
+		return fmt.Errorf("btree: tree holds %d entries, Len is %d", size, self.size)
+	}
+	if last.ref != self.tail || last.neighbor != nil {
+		return fmt.Errorf("btree: the last leaf is not the tail of the chain")
+	}
+	return nil
+}
+
+// Check the structure of the tree: keys are ordered within nodes and between
+// the keys above them, nodes other than the root are at least half full and
+// at most full, leaves are all at the same depth and Stats agrees with the
+// nodes that are found. Returns an error describing the first problem found.
+func (tree *BTree[K, V]) Validate() error {
+	depth := -1
+	nodes, leaves := 0, 0
+	var check func (n *bNode[K, V], level int, lo, hi *K) (int, error)
+	check = func(n *bNode[K, V], level int, lo, hi *K) (int, error) {
+		if n != tree.root && len(n.Values) < tree.N/2 {
+			return 0, fmt.Errorf("btree: node at depth %d has %d keys, less than %d", level, len(n.Values), tree.N/2)
+		}
+		if len(n.Values) > tree.N {
+			return 0, fmt.Errorf("btree: node at depth %d has %d keys, more than %d", level, len(n.Values), tree.N)
+		}
+		for i, p := range n.Values {
+			if i > 0 && tree.compare(n.Values[i-1].key, p.key) >= 0 {
+				return 0, fmt.Errorf("btree: keys out of order at depth %d: %v, %v", level, n.Values[i-1].key, p.key)
+			}
+			if (lo != nil && tree.compare(p.key, *lo) <= 0) || (hi != nil && tree.compare(p.key, *hi) >= 0) {
+				return 0, fmt.Errorf("btree: key %v at depth %d is outside of the keys above it", p.key, level)
+			}
+		}
+
+		if n.Nodes == nil {
+			if depth < 0 {
+				depth = level
+			}else if depth != level {
+				return 0, fmt.Errorf("btree: leaves at depth %d and %d", depth, level)
+			}
+			leaves++
+			return len(n.Values), nil
+		}
+
+		if len(n.Nodes) != len(n.Values) + 1 {
+			return 0, fmt.Errorf("btree: node at depth %d has %d keys and %d links", level, len(n.Values), len(n.Nodes))
+		}
+		nodes++
+		total := len(n.Values)
+		for i, c := range n.Nodes {
+			clo, chi := lo, hi
+			if i > 0 {
+				clo = &n.Values[i-1].key
+			}
+			if i < len(n.Values) {
+				chi = &n.Values[i].key
+			}
+			count, err := check(c, level + 1, clo, chi)
+			if err != nil {
+				return 0, err
+			}
+			total += count
+		}
+		return total, nil
+	}
+
+	size, err := check(tree.root, 0, nil, nil)
+	if err != nil {
+		return err
+	}
+	switch {
+	case size != tree.Stats.Size:
+		return fmt.Errorf("btree: tree holds %d entries, Stats.Size is %d", size, tree.Stats.Size)
+	case depth != tree.Stats.Depth:
+		return fmt.Errorf("btree: leaves are at depth %d, Stats.Depth is %d", depth, tree.Stats.Depth)
+	case nodes != tree.Stats.Nodes:
+		return fmt.Errorf("btree: tree has %d internal nodes, Stats.Nodes is %d", nodes, tree.Stats.Nodes)
+	case leaves != tree.Stats.Leaves:
+		return fmt.Errorf("btree: tree has %d leaves, Stats.Leaves is %d", leaves, tree.Stats.Leaves)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"maps"
+	"slices"
+)
+
+var (
+	ErrNotLog = errors.New("btree: file does not start with a log header")
+	ErrPartialPage = errors.New("btree: log writes must be whole pages")
+)
+
+// The log starts with a header:
+//
+//	magic [4]byte, version, page size, epoch uint32
+//
+// followed by records:
+//
+//	kind, epoch, page, checksum uint32, the image of the page for walPage
+//
+// Records of an earlier epoch or with a bad checksum end the log
+const (
+	walMagic = "BTWL"
+	walVersion = 1
+	walHeader = 16
+	walRecord = 16
+)
+
+const (
+	walPage = iota + 1
+	walCommit
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// A WAL is a PageFile that logs every page written to it until Sync, which
+// logs a commit and only then copies the pages to the file. After a crash
+// OpenWAL copies the pages up to the last commit that made it to the log and
+// drops the rest, so the file is left as it was at one of the commits. Use it
+// as the file of a PageFactory and call Commit on the tree.
+type WAL struct {
+	file PageFile
+	log PageFile
+	size int
+	epoch uint32
+	end int64
+	pages map[uint32] int64
+}
+
+// Start a new log for file with pages of size bytes
+func NewWAL(file, log PageFile, size int) (*WAL, error) {
+	self := &WAL{file: file, log: log, size: size, epoch: 1, end: walHeader, pages: map[uint32] int64 {}}
+	if err := self.header(); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+// Open the log of file and recover the pages of the last commit
+func OpenWAL(file, log PageFile) (*WAL, error) {
+	buf := make ([] byte, walHeader)
+	if _, err := log.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+	if string(buf[0:4]) != walMagic || binary.LittleEndian.Uint32(buf[4:]) != walVersion {
+		return nil, ErrNotLog
+	}
+	self := &WAL{file: file, log: log, end: walHeader, pages: map[uint32] int64 {}}
+	self.size = int(binary.LittleEndian.Uint32(buf[8:]))
+	self.epoch = binary.LittleEndian.Uint32(buf[12:])
+
+	// Pages after the last commit are dropped
+	committed := map[uint32] int64 {}
+	for {
+		kind, page, ok := self.record(self.end)
+		if ! ok {
+			break
+		}
+		if kind == walCommit {
+			maps.Copy(committed, self.pages)
+			clear(self.pages)
+			self.end += walRecord
+			continue
+		}
+		self.pages[page] = self.end
+		self.end += walRecord + int64(self.size)
+	}
+	if err := self.checkpoint(committed); err != nil {
+		return nil, err
+	}
+	return self, nil
+}
+
+// Read from the last logged image of the page, or the file when the page
+// was not written since the last Sync
+func (self *WAL) ReadAt(p [] byte, off int64) (int, error) {
+	page, within := uint32(off / int64(self.size)), off % int64(self.size)
+	if within + int64(len(p)) > int64(self.size) {
+		return 0, ErrPartialPage
+	}
+	if at, ok := self.pages[page]; ok {
+		return self.log.ReadAt(p, at + walRecord + within)
+	}
+	return self.file.ReadAt(p, off)
+}
+
+// Log a whole page
+func (self *WAL) WriteAt(p [] byte, off int64) (int, error) {
+	if off % int64(self.size) != 0 || len(p) != self.size {
+		return 0, ErrPartialPage
+	}
+	page := uint32(off / int64(self.size))
+	if err := self.append(walPage, page, p); err != nil {
+		return 0, err
+	}
+	self.pages[page] = self.end - walRecord - int64(self.size)
+	return len(p), nil
+}
+
+// Log a commit and copy the pages logged since the last Sync to the file
+func (self *WAL) Sync() error {
+	if len(self.pages) == 0 {
+		return nil
+	}
+	if err := self.append(walCommit, 0, nil); err != nil {
+		return err
+	}
+	if err := syncFile(self.log); err != nil {
+		return err
+	}
+	return self.checkpoint(self.pages)
+}
+
+// Copy pages from the log to the file and start the log over in a new epoch,
+// which ends the log at its first record
+func (self *WAL) checkpoint(pages map[uint32] int64) error {
+	buf := make ([] byte, self.size)
+	for _, page := range slices.Sorted(maps.Keys(pages)) {
+		if _, err := self.log.ReadAt(buf, pages[page] + walRecord); err != nil {
+			return err
+		}
+		if _, err := self.file.WriteAt(buf, int64(page) * int64(self.size)); err != nil {
+			return err
+		}
+	}
+	if err := syncFile(self.file); err != nil {
+		return err
+	}
+	self.epoch++
+	self.end = walHeader
+	clear(self.pages)
+	return self.header()
+}
+
+func (self *WAL) header() error {
+	buf := make ([] byte, walHeader)
+	copy(buf, walMagic)
+	binary.LittleEndian.PutUint32(buf[4:], walVersion)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(self.size))
+	binary.LittleEndian.PutUint32(buf[12:], self.epoch)
+	if _, err := self.log.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	return syncFile(self.log)
+}
+
+// Write a record at the end of the log
+func (self *WAL) append(kind uint32, page uint32, image [] byte) error {
+	buf := make ([] byte, walRecord + len(image))
+	binary.LittleEndian.PutUint32(buf[0:], kind)
+	binary.LittleEndian.PutUint32(buf[4:], self.epoch)
+	binary.LittleEndian.PutUint32(buf[8:], page)
+	copy(buf[walRecord:], image)
+	binary.LittleEndian.PutUint32(buf[12:], checksum(buf))
+	if _, err := self.log.WriteAt(buf, self.end); err != nil {
+		return err
+	}
+	self.end += int64(len(buf))
+	return nil
+}
+
+// Read the record at off, ok is false at the end of the log
+func (self *WAL) record(off int64) (kind uint32, page uint32, ok bool) {
+	buf := make ([] byte, walRecord + self.size)
+	if _, err := self.log.ReadAt(buf[:walRecord], off); err != nil {
+		return
+	}
+	kind = binary.LittleEndian.Uint32(buf[0:])
+	page = binary.LittleEndian.Uint32(buf[8:])
+	if binary.LittleEndian.Uint32(buf[4:]) != self.epoch {
+		return
+	}
+	switch kind {
+	case walCommit:
+		buf = buf[:walRecord]
+	case walPage:
+		if _, err := self.log.ReadAt(buf[walRecord:], off + walRecord); err != nil {
+			return
+		}
+	default:
+		return
+	}
+	ok = checksum(buf) == binary.LittleEndian.Uint32(buf[12:])
+	return
+}
+
+// The CRC32C of a record or a page, leaving out the checksum in bytes 12 to 16
+func checksum(buf [] byte) uint32 {
+	crc := crc32.Checksum(buf[:12], castagnoli)
+	return crc32.Update(crc, castagnoli, buf[walRecord:])
+}
+
+// Sync f if it has a Sync method, like *os.File
+func syncFile(f PageFile) error {
+	if s, ok := f.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}